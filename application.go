@@ -1,10 +1,10 @@
 package communication
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"time"
 
 	"github.com/pkg/errors"
@@ -15,14 +15,21 @@ import (
 const UserAgent = "InteractiveSolutions/GoCommunication-1.0"
 
 type Application interface {
+	TemplateService
+
 	HttpHandler() *HttpHandler
-	SendEmail(id, locale, email, externalId string, params map[string]interface{}) error
-	SendSms(id, locale, number, externalId string, params map[string]interface{}) error
+	SendEmail(id, locale, email, externalId string, params map[string]interface{}, options ...SendOption) error
+	SendSms(id, locale, number, externalId string, params map[string]interface{}, options ...SendOption) error
+	SendInbox(id, locale, recipient, externalId string, params map[string]interface{}, options ...SendOption) error
+	SendWithFallback(id, locale, externalId string, params map[string]interface{}, steps []FallbackStep, options ...SendOption) error
+	ConfirmDelivery(jobUuid uuid.UUID) error
+	TemplateHealth(locales ...string) (TemplateHealthReport, error)
+	ImportTemplates(bundle TemplateBundle, dryRun bool, author string) ([]TemplateChange, error)
 	Shutdown(ctx context.Context)
 }
 
 type AppOption func(a *application)
-type RenderFunc func(body string, params map[string]interface{}) (string, error)
+type SendOption func(job *Job)
 
 func SetFallbackLocale(locale string) AppOption {
 	return func(a *application) {
@@ -42,6 +49,23 @@ func SetDefaultEmailTransport(transport Transport) AppOption {
 	}
 }
 
+func SetDefaultInboxTransport(transport Transport) AppOption {
+	return func(a *application) {
+		a.defaultInboxTransport = transport
+	}
+}
+
+// SetInboxRepo enables the inbox channel, storing messages in the given repository
+func SetInboxRepo(repo InboxRepository) AppOption {
+	return func(a *application) {
+		a.inboxRepo = repo
+
+		if a.defaultInboxTransport == nil {
+			a.defaultInboxTransport = NewInboxTransport(repo)
+		}
+	}
+}
+
 func SetTemplateRepo(repo TemplateRepository) AppOption {
 	return func(a *application) {
 		a.templateRepo = repo
@@ -72,6 +96,12 @@ func SetHtmlToTextConverter(f func (string) string) AppOption {
 	}
 }
 
+func SetAttachmentLoader(loader AttachmentLoader) AppOption {
+	return func(a *application) {
+		a.attachmentLoader = loader
+	}
+}
+
 func SetLogger(logger logrus.FieldLogger) AppOption {
 	return func (a *application) {
 		a.logger = logger
@@ -95,16 +125,41 @@ type application struct {
 
 	templateRepo TemplateRepository
 	jobRepo      JobRepository
+	inboxRepo    InboxRepository
 
 	fallbackLocale        string
+	localeFallbacks       map[string][]string
+
+	missingTemplatePolicy MissingTemplatePolicy
+
+	templateVersionRepo TemplateVersionRepository
+	authorResolver      AuthorResolver
+
+	draftWorkflow   bool
+	publishApproval bool
+
+	templateSeed          fs.FS
+	updateSeededTemplates bool
 	defaultSmsTransport   Transport
 	defaultEmailTransport Transport
+	defaultInboxTransport Transport
+
+	routes []Route
 
 	templateFuncMap template.FuncMap
 
 	htmlToTextConverter func (string) string
 
 	staticParams map[string]interface{}
+
+	attachmentLoader AttachmentLoader
+
+	templateCache       *templateCache
+	templateInvalidator TemplateInvalidator
+
+	bodyCompilers map[BodyFormat]BodyCompiler
+
+	cssInlining bool
 }
 
 func NewApplication(options ...AppOption) (Application, error) {
@@ -113,6 +168,14 @@ func NewApplication(options ...AppOption) (Application, error) {
 
 		workerQueue: make(chan *Job, 1000),
 		workerCount: 5,
+
+		templateCache: newTemplateCache(),
+
+		missingTemplatePolicy: MissingTemplateSend,
+
+		bodyCompilers: map[BodyFormat]BodyCompiler{
+			BodyFormatMarkdown: MarkdownCompiler,
+		},
 	}
 
 	for _, option := range options {
@@ -123,11 +186,24 @@ func NewApplication(options ...AppOption) (Application, error) {
 		return app, err
 	}
 
+	if app.templateSeed != nil {
+		if err := app.seedTemplates(); err != nil {
+			return app, err
+		}
+	}
+
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 
+	app.workerCtx = ctx
 	app.workerCancel = cancel
 
+	if app.templateInvalidator != nil {
+		if err := app.templateInvalidator.Subscribe(ctx, app.templateCache.invalidate); err != nil {
+			return app, err
+		}
+	}
+
 	for i := 0; i <= app.workerCount; i++ {
 		go app.worker(ctx)
 	}
@@ -144,6 +220,10 @@ func NewApplication(options ...AppOption) (Application, error) {
 		app.queue(&cpy)
 	}
 
+	if err := app.resumeEscalations(); err != nil {
+		return app, err
+	}
+
 	return app, nil
 }
 
@@ -153,47 +233,106 @@ func (a *application) HttpHandler() *HttpHandler {
 	}
 }
 
-func (a *application) SendEmail(id, locale, email, externalId string, params map[string]interface{}) error {
-	if a.defaultEmailTransport == nil {
+func (a *application) SendEmail(id, locale, email, externalId string, params map[string]interface{}, options ...SendOption) error {
+	if !a.hasTransport(JobEmail) {
 		return errors.New("No email transport configured")
 	}
 
-	job := &Job{
-		Uuid:       uuid.New(),
-		ExternalId: externalId,
-		Type:       JobEmail,
-		TemplateId: id,
-		Locale:     locale,
-		Target:     email,
-		Params:     params,
-		CreatedAt:  time.Now(),
-	}
+	return a.send(JobEmail, id, locale, email, externalId, params, options)
+}
 
-	if err := a.jobRepo.Create(job); err != nil {
-		return err
+func (a *application) SendSms(id, locale, number, externalId string, params map[string]interface{}, options ...SendOption) error {
+	if !a.hasTransport(JobSms) {
+		return errors.New("No sms transport configured")
 	}
 
-	a.queue(job)
+	return a.send(JobSms, id, locale, number, externalId, params, options)
+}
 
-	return nil
+func (a *application) SendInbox(id, locale, recipient, externalId string, params map[string]interface{}, options ...SendOption) error {
+	if !a.hasTransport(JobInbox) {
+		return errors.New("No inbox transport configured")
+	}
+
+	return a.send(JobInbox, id, locale, recipient, externalId, params, options)
 }
 
-func (a *application) SendSms(id, locale, number, externalId string, params map[string]interface{}) error {
-	if a.defaultSmsTransport == nil {
-		return errors.New("No sms transport configured")
+func (a *application) send(jobType JobType, id, locale, target, externalId string, params map[string]interface{}, options []SendOption) error {
+	job, err := a.newJob(jobType, id, locale, target, externalId, params, options)
+	if err != nil {
+		return err
 	}
 
+	return a.enqueue(job)
+}
+
+// newJob resolves the template of a new job and validates the job against it without storing anything
+func (a *application) newJob(jobType JobType, id, locale, target, externalId string, params map[string]interface{}, options []SendOption) (*Job, error) {
 	job := &Job{
 		Uuid:       uuid.New(),
 		ExternalId: externalId,
-		Type:       JobSms,
+		Type:       jobType,
 		TemplateId: id,
 		Locale:     locale,
-		Target:     number,
-		Params:     params,
+		Target:     target,
 		CreatedAt:  time.Now(),
 	}
 
+	for _, option := range options {
+		option(job)
+	}
+
+	tpl, err := a.resolveJobTemplate(id, locale)
+
+	// Test sends of drafts use the requested template, which may not be enabled before publishing
+	if job.Draft {
+		var requested Template
+		if requested, err = a.lookupTemplate(id, locale); err == nil {
+			tpl = &requested
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	schema := tpl
+	if job.Draft {
+		drafted := tpl.drafted()
+		schema = &drafted
+	}
+
+	job.Params, err = applySchema(schema, params)
+	if err != nil {
+		return nil, err
+	}
+
+	job.template = tpl
+
+	if len(job.Attachments) > 0 && job.Type != JobEmail {
+		return nil, errors.Errorf("Attachments are not supported for job type %s", job.Type)
+	}
+
+	if err := a.validateAttachments(job.Attachments); err != nil {
+		return nil, err
+	}
+
+	if err := job.validateRecipients(); err != nil {
+		return nil, err
+	}
+
+	if err := job.validateHeaders(); err != nil {
+		return nil, err
+	}
+
+	if err := job.validateMetadata(); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (a *application) enqueue(job *Job) error {
 	if err := a.jobRepo.Create(job); err != nil {
 		return err
 	}
@@ -217,6 +356,10 @@ func (a *application) ensureUsableConfiguration() error {
 		return errors.New("Missing transaction repository")
 	}
 
+	if a.publishApproval && a.authorResolver == nil {
+		return errors.New("Publish approval requires an author resolver")
+	}
+
 	return nil
 }
 
@@ -237,18 +380,28 @@ func (a *application) worker(ctx context.Context) {
 				return
 			}
 
-			if err := a.process(job); err != nil {
+			err := a.process(job)
+			if err == jobHeldErr {
+				a.holdJob(job)
+
+				continue
+			}
+
+			if err != nil {
 				a.logger.
 					WithField("job", job).
 					WithError(err).
 					Error("failed to process job")
 
+				a.handleFailure(job, err)
+
 				continue
 			}
 
 			now := time.Now()
 
 			job.SentAt = &now
+			job.EscalateAt = job.escalationDeadline(now)
 
 			if err := a.jobRepo.Update(job); err != nil {
 				a.logger.
@@ -256,17 +409,21 @@ func (a *application) worker(ctx context.Context) {
 					WithError(err).
 					Error("failed to update job in transaction repo")
 			}
+
+			a.handleSent(job)
 		}
 	}
 }
 
+const placeholderSubject = "[InteractiveSolutions/Communications] template missing"
+
 func (a *application) createMockTemplate(templateId, locale string) (Template, error) {
 	tpl := Template{
 		TemplateId:       templateId,
 		Locale:           locale,
 		UpdateParameters: true,
 
-		Subject:  "[InteractiveSolutions/Communications] template missing",
+		Subject:  placeholderSubject,
 		TextBody: fmt.Sprintf("A template is missing for template id: %s, locale: %s", templateId, locale),
 		HtmlBody: fmt.Sprintf("A template is missing for template id: %s, locale: %s", templateId, locale),
 
@@ -282,7 +439,7 @@ func (a *application) createMockTemplate(templateId, locale string) (Template, e
 }
 
 func (a *application) getFallbackTemplate(templateId string) (Template, error) {
-	tpl, err := a.templateRepo.Get(templateId, a.fallbackLocale)
+	tpl, err := a.lookupTemplate(templateId, a.fallbackLocale)
 	switch err {
 	case nil:
 		return tpl, nil
@@ -296,97 +453,154 @@ func (a *application) getFallbackTemplate(templateId string) (Template, error) {
 }
 
 func (a *application) getTemplate(templateId, locale string) (Template, error) {
-	tpl, err := a.templateRepo.Get(templateId, locale)
-	switch err {
-	case nil:
-		if tpl.Enabled {
-			return tpl, nil
-		}
+	for i, candidate := range a.jobLocaleChain(locale) {
+		tpl, err := a.lookupTemplate(templateId, candidate)
+		switch err {
+		case nil:
+			if tpl.Enabled {
+				return tpl, nil
+			}
+
+		case TemplateNotFoundErr:
+			if i > 0 || !a.createsPlaceholders() {
+				continue
+			}
 
-		return a.getFallbackTemplate(templateId)
+			// Only the requested locale is created so it shows up in the administration panel
+			if _, err := a.createMockTemplate(templateId, locale); err != nil {
+				a.logger.
+					WithField("templateId", templateId).
+					WithField("locale", locale).
+					WithError(err).
+					Error("Failed to create mock template")
+			}
 
-	case TemplateNotFoundErr:
-		if _, err := a.createMockTemplate(templateId, locale); err != nil {
-			a.logger.
-				WithField("templateId", templateId).
-				WithField("locale", locale).
-				WithError(err).
-				Error("Failed to create mock template")
+		default:
+			return tpl, err
 		}
+	}
 
-		return a.getFallbackTemplate(templateId)
+	return a.missingTemplate(templateId, locale)
+}
 
-	default:
-		return tpl, err
+// templateFor returns the template resolved when the job was created, or looks it up for jobs loaded from the repository
+func (a *application) templateFor(job *Job) (Template, error) {
+	if job.template != nil {
+		return *job.template, nil
+	}
+
+	// Test sends of drafts use the requested template, which may not be enabled before publishing
+	if job.Draft {
+		return a.lookupTemplate(job.TemplateId, job.Locale)
+	}
+
+	return a.getTemplate(job.TemplateId, job.Locale)
+}
+
+func (a *application) recordParameters(tpl Template, params map[string]interface{}) error {
+	if !tpl.UpdateParameters {
+		return nil
+	}
+
+	// The template may come from the cache, so re-read it to avoid overwriting newer changes
+	current, err := a.templateRepo.Get(tpl.TemplateId, tpl.Locale)
+	if err != nil {
+		return err
 	}
+
+	if !current.UpdateParameters {
+		return nil
+	}
+
+	current.Parameters = params
+	current.UpdateParameters = false
+
+	if err := a.templateRepo.Update(&current); err != nil {
+		return err
+	}
+
+	a.invalidateTemplate(current.TemplateId, current.Locale)
+
+	return nil
 }
 
 func (a *application) process(job *Job) error {
-	tpl, err := a.getTemplate(job.TemplateId, job.Locale)
+	tpl, err := a.templateFor(job)
 	if err != nil {
 		return err
 	}
 
-	if tpl.UpdateParameters {
-		tpl.Parameters = job.Params
-		tpl.UpdateParameters = false
+	job.ResolvedLocale = tpl.Locale
+	job.TemplateVersion = tpl.Version
 
-		if err := a.templateRepo.Update(&tpl); err != nil {
-			return err
-		}
+	if err := a.recordParameters(tpl, job.Params); err != nil {
+		return err
 	}
 
-	switch job.Type {
-	case JobSms:
-		return a.defaultSmsTransport.Send(context.Background(), job, tpl, a.render)
+	transport := a.transportFor(job)
+	if transport == nil {
+		return errors.Errorf("No transport configured for job type %s", job.Type)
+	}
 
-	case JobEmail:
-		return a.defaultEmailTransport.Send(context.Background(), job, tpl, a.render)
+	for i := range job.Attachments {
+		job.Attachments[i].loader = a.attachmentLoader
+	}
 
-	default:
-		return errors.Errorf("Unknown job type %s", job.Type)
+	render := a.renderFunc(tpl)
+
+	// Drafts change with every edit, so they are compiled without using the cache
+	if job.Draft && tpl.Draft != nil {
+		tpl = tpl.drafted()
+		render = a.uncachedRenderFunc(tpl)
 	}
-}
 
-func (a *application) Render(template Template, job *Job) (subject, text, html string, err error) {
-	subject, err = a.render(template.Subject, job.Params)
-	if err != nil {
-		return
+	if job.Type == JobEmail && a.cssInlining {
+		render = inlineCssRender(render)
 	}
 
-	text, err = a.render(template.TextBody, job.Params)
-	if err != nil {
-		return
+	if job.Type == JobSms {
+		render = smsLimitRender(render, tpl)
 	}
 
-	html, err = a.render(template.HtmlBody, job.Params)
-	return
+	return transport.Send(context.Background(), job, tpl, render)
 }
 
-func (a *application) render(body string, params map[string]interface{}) (string, error) {
-	if params == nil {
-		params = map[string]interface{}{}
+func (a *application) transportFor(job *Job) Transport {
+	for _, route := range a.routes {
+		if route.matches(job) {
+			return route.Transport
+		}
 	}
 
-	for key, value := range a.staticParams {
-		if _, ok := params[key]; ok {
-			// Allow dynamic parameters to overwrite static parameters
-			continue
-		}
+	return a.defaultTransport(job.Type)
+}
 
-		params[key] = value
+func (a *application) hasTransport(jobType JobType) bool {
+	if a.defaultTransport(jobType) != nil {
+		return true
 	}
 
-	tpl, err := template.New("").Funcs(a.templateFuncMap).Parse(body)
-	if err != nil {
-		return "", err
+	for _, route := range a.routes {
+		if route.Type == jobType && route.Transport != nil {
+			return true
+		}
 	}
 
-	out := &bytes.Buffer{}
+	return false
+}
 
-	if err := tpl.Execute(out, params); err != nil {
-		return "", err
-	}
+func (a *application) defaultTransport(jobType JobType) Transport {
+	switch jobType {
+	case JobSms:
+		return a.defaultSmsTransport
 
-	return out.String(), nil
+	case JobEmail:
+		return a.defaultEmailTransport
+
+	case JobInbox:
+		return a.defaultInboxTransport
+
+	default:
+		return nil
+	}
 }
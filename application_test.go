@@ -1,11 +1,22 @@
 package communication
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"html/template"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -60,7 +71,7 @@ func (suite *applicationTestSuite) TestRenderWithCustomFunc() {
 		Params: tpl.Parameters,
 	}
 
-	subject, text, html, err := app.(*application).Render(tpl, job)
+	subject, text, html, err := app.(*application).renderAll(tpl, job)
 	if !assert.NoError(suite.T(), err, "Failed to render the template") {
 		return
 	}
@@ -70,24 +81,1763 @@ func (suite *applicationTestSuite) TestRenderWithCustomFunc() {
 	assert.Equal(suite.T(), "html body https://interactivesolutions.se?ref=MTAw", html)
 }
 
+func (suite *applicationTestSuite) TestRenderOnlyEscapesHtml() {
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(&templateRepository{}),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	tpl := Template{
+		Subject:  "Welcome {{ .name }}",
+		TextBody: "Hi {{ .name }}",
+		HtmlBody: "<p>Hi {{ .name }}</p>",
+	}
+
+	job := &Job{
+		Params: map[string]interface{}{
+			"name": "O'Brien & Sons",
+		},
+	}
+
+	subject, text, html, err := app.(*application).renderAll(tpl, job)
+	if !assert.NoError(suite.T(), err, "Failed to render the template") {
+		return
+	}
+
+	assert.Equal(suite.T(), "Welcome O'Brien & Sons", subject)
+	assert.Equal(suite.T(), "Hi O'Brien & Sons", text)
+	assert.Equal(suite.T(), "<p>Hi O&#39;Brien &amp; Sons</p>", html)
+}
+
+func (suite *applicationTestSuite) TestMarkdownBody() {
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(&templateRepository{}),
+		SetBodyCompiler("upper", BodyCompilerFunc(func(body string) (string, error) {
+			return strings.ToUpper(body), nil
+		})),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	tpl := Template{
+		BodyFormat: BodyFormatMarkdown,
+		HtmlBody: "# Hello {{.name}}\n\n" +
+			"Visit [your account]({{.url}}) now\n\n" +
+			"{{if eq .plan \"pro\"}}**Pro & more**{{end}}\n\n" +
+			"![logo]({{.logoUrl}} \"{{.title}}\")\n",
+	}
+
+	html, err := app.(*application).compileHtmlBody(tpl)
+	if !assert.NoError(suite.T(), err, "Failed to compile the markdown body") {
+		return
+	}
+
+	assert.Equal(suite.T(), "<h1>Hello {{.name}}</h1>\n\n"+
+		"<p>Visit <a href=\"{{.url}}\">your account</a> now</p>\n\n"+
+		"<p>{{if eq .plan \"pro\"}}<strong>Pro &amp; more</strong>{{end}}</p>\n\n"+
+		"<p><img src=\"{{.logoUrl}}\" alt=\"logo\" title=\"{{.title}}\" /></p>\n", html, "Template actions should survive compilation untouched")
+
+	job := &Job{
+		Params: map[string]interface{}{
+			"name":    "Anna",
+			"url":     "https://example.com/account?a=1&b=2",
+			"plan":    "pro",
+			"logoUrl": "https://example.com/logo.png",
+			"title":   `Acme "Shop"`,
+		},
+	}
+
+	_, _, rendered, err := app.(*application).renderAll(tpl, job)
+	if !assert.NoError(suite.T(), err, "Failed to render the template") {
+		return
+	}
+
+	assert.Contains(suite.T(), rendered, "<h1>Hello Anna</h1>")
+	assert.Contains(suite.T(), rendered, `<a href="https://example.com/account?a=1&amp;b=2">your account</a>`)
+	assert.Contains(suite.T(), rendered, "<strong>Pro &amp; more</strong>")
+	assert.Contains(suite.T(), rendered, `title="Acme &#34;Shop&#34;"`)
+
+	// Actions are restored after compiling, so compilers never see or change them
+	html, err = app.(*application).compileHtmlBody(Template{BodyFormat: "upper", HtmlBody: "hi {{.name}} {{range .items}}{{.}}{{end}}"})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "HI {{.name}} {{range .items}}{{.}}{{end}}", html)
+
+	var many []string
+	for i := 0; i < 12; i++ {
+		many = append(many, "{{.p"+strconv.Itoa(i)+"}}")
+	}
+
+	html, err = app.(*application).compileHtmlBody(Template{BodyFormat: "upper", HtmlBody: strings.Join(many, " ")})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), strings.Join(many, " "), html, "Placeholders of ten and above should not be mixed up")
+
+	_, err = app.(*application).compileHtmlBody(Template{BodyFormat: BodyFormatMjml, HtmlBody: "<mjml></mjml>"})
+	assert.Error(suite.T(), err, "Formats without a compiler should fail")
+}
+
+func (suite *applicationTestSuite) TestTemplateResolvedOnce() {
+	repo := &templateRepository{GetTemplate: Template{TemplateId: "welcome", Locale: "en", Enabled: true, Subject: "Hi {{.name}}"}}
+	email := &transport{Sent: make(chan *Job, 1)}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(repo),
+		SetDefaultEmailTransport(email),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	if !assert.NoError(suite.T(), app.SendEmail("welcome", "en", "test@example.com", "", nil)) {
+		return
+	}
+
+	select {
+	case <-email.Sent:
+		assert.Equal(suite.T(), 1, repo.Gets, "The template resolved when validating the parameters should be used to send")
+
+	case <-time.After(time.Second):
+		suite.T().Error("Job was never sent")
+	}
+
+	repo.Gets = 0
+
+	rendered, err := app.Render("welcome", "en", map[string]interface{}{"name": "Anna"})
+	if assert.NoError(suite.T(), err) {
+		assert.Equal(suite.T(), "Hi Anna", rendered.Subject)
+		assert.Equal(suite.T(), 1, repo.Gets)
+	}
+}
+
+func (suite *applicationTestSuite) TestTemplateCacheInvalidation() {
+	repo := &templateRepository{GetTemplate: Template{TemplateId: "welcome", Locale: "en"}}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(repo),
+		SetTemplateCacheTTL(time.Minute),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	a := app.(*application)
+
+	for i := 0; i < 2; i++ {
+		_, err := a.lookupTemplate("welcome", "en")
+		assert.NoError(suite.T(), err)
+	}
+
+	assert.Equal(suite.T(), 1, repo.Gets)
+
+	a.invalidateTemplate("welcome", "en")
+
+	_, err = a.lookupTemplate("welcome", "en")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 2, repo.Gets)
+}
+
+func (suite *applicationTestSuite) TestRenderWithLayoutAndPartials() {
+	repo := &templateRepository{Templates: map[string]Template{
+		"base:en": {
+			TemplateId: "base",
+			Locale:     "en",
+			Kind:       TemplateKindLayout,
+			Enabled:    true,
+			HtmlBody:   "<html>{{ template \"content\" . }}{{ template \"footer\" . }}</html>",
+		},
+		"footer:sv": {
+			TemplateId: "footer",
+			Locale:     "sv",
+			Kind:       TemplateKindPartial,
+			Enabled:    true,
+			HtmlBody:   "<footer>{{ .company }}</footer>",
+		},
+		"header:en": {
+			TemplateId: "header",
+			Locale:     "en",
+			Kind:       TemplateKindPartial,
+			HtmlBody:   "<header>{{ .company }}</header>",
+		},
+		"welcome:en": {
+			TemplateId: "welcome",
+			Locale:     "en",
+			Enabled:    true,
+			HtmlBody:   "<p>Welcome</p>",
+		},
+	}}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(repo),
+		SetFallbackLocale("en"),
+		SetStaticParams(map[string]interface{}{"company": "Acme"}),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	tpl := Template{
+		TemplateId: "welcome",
+		Locale:     "sv",
+		Layout:     "base",
+		TextBody:   "Hej {{ .name }}",
+		HtmlBody:   "<p>Hej {{ .name }}</p>",
+	}
+
+	_, text, html, err := app.(*application).renderAll(tpl, &Job{Params: map[string]interface{}{"name": "Anna"}})
+	if !assert.NoError(suite.T(), err, "Failed to render the template") {
+		return
+	}
+
+	assert.Equal(suite.T(), "Hej Anna", text)
+	assert.Equal(suite.T(), "<html><p>Hej Anna</p><footer>Acme</footer></html>", html)
+
+	tpl.Layout = "missing"
+
+	_, _, _, err = app.(*application).renderAll(tpl, &Job{})
+	assert.Error(suite.T(), err, "Rendering with a missing layout should fail")
+
+	tpl.Layout = "welcome"
+
+	_, _, _, err = app.(*application).renderAll(tpl, &Job{})
+	assert.Error(suite.T(), err, "Content templates should not be used as layouts")
+
+	tpl.Layout = ""
+	tpl.HtmlBody = `{{ template "base" . }}`
+
+	_, _, _, err = app.(*application).renderAll(tpl, &Job{})
+	assert.Error(suite.T(), err, "Layouts should not be used as partials")
+
+	tpl.HtmlBody = `{{ template "header" . }}`
+
+	_, _, _, err = app.(*application).renderAll(tpl, &Job{})
+	assert.Error(suite.T(), err, "Disabled partials should not be used")
+}
+
+func (suite *applicationTestSuite) TestInlineCss() {
+	body := `<html><head><style>
+		p { color: red; font-size: 12px }
+		.lead { color: blue }
+		a:hover { color: green }
+		@media (max-width: 600px) { p { font-size: 16px } }
+	</style></head><body><p class="lead" style="margin: 0">Hi</p><p>There</p></body></html>`
+
+	out, err := inlineCss(body)
+	if !assert.NoError(suite.T(), err, "Failed to inline css") {
+		return
+	}
+
+	assert.Contains(suite.T(), out, `<p class="lead" style="color: blue; font-size: 12px; margin: 0;">Hi</p>`)
+	assert.Contains(suite.T(), out, `<p style="color: red; font-size: 12px;">There</p>`)
+	assert.Contains(suite.T(), out, "a:hover { color: green }")
+	assert.Contains(suite.T(), out, "@media (max-width: 600px) { p { font-size: 16px } }")
+	assert.NotContains(suite.T(), out, ".lead")
+
+	body = `<html><head><style>
+		.logo { background: url(data:image/png;base64,iVBORw0KGgo=) no-repeat; width: 10px }
+		.quote { font-family: "Helvetica; Neue", sans-serif; content: "}" }
+		.icon { background-image: url("https://example.com/a;b.png") !important }
+	</style></head><body><div class="logo">A</div><div class="quote">B</div><div class="icon" style="content: 'x;y'">C</div></body></html>`
+
+	out, err = inlineCss(body)
+	if !assert.NoError(suite.T(), err, "Failed to inline css") {
+		return
+	}
+
+	assert.Contains(suite.T(), out, `<div class="logo" style="background: url(data:image/png;base64,iVBORw0KGgo=) no-repeat; width: 10px;">A</div>`)
+	assert.Contains(suite.T(), out, `<div class="quote" style="font-family: &#34;Helvetica; Neue&#34;, sans-serif; content: &#34;}&#34;;">B</div>`)
+	assert.Contains(suite.T(), out, `<div class="icon" style="background-image: url(&#34;https://example.com/a;b.png&#34;) !important; content: &#39;x;y&#39;;">C</div>`)
+
+	body = `<html><head><style>
+		a[href^="http:"], a[title="a, b"] { color: red }
+		a[href^="http:"]:hover { color: green }
+	</style></head><body><a href="http://example.com">A</a><a href="/b" title="a, b">B</a></body></html>`
+
+	out, err = inlineCss(body)
+	if !assert.NoError(suite.T(), err, "Failed to inline css") {
+		return
+	}
+
+	assert.Contains(suite.T(), out, `<a href="http://example.com" style="color: red;">A</a>`, "Colons in attribute selectors are not pseudo classes")
+	assert.Contains(suite.T(), out, `<a href="/b" title="a, b" style="color: red;">B</a>`)
+	assert.Contains(suite.T(), out, `a[href^="http:"]:hover { color: green }`)
+}
+
+func (suite *applicationTestSuite) TestParameterSchema() {
+	repo := &templateRepository{Templates: map[string]Template{
+		"reset:en": {
+			TemplateId: "reset",
+			Locale:     "en",
+			Enabled:    true,
+			Schema: ParameterSchema{
+				{Name: "resetUrl", Type: ParameterString, Format: FormatUrl, Required: true},
+				{Name: "name", Type: ParameterString, Default: "there"},
+			},
+		},
+	}}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(repo),
+		SetDefaultEmailTransport(&transport{Sent: make(chan *Job, 1)}),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	err = app.SendEmail("reset", "en", "test@example.com", "", map[string]interface{}{})
+	if assert.IsType(suite.T(), &InvalidParametersError{}, err) {
+		assert.Equal(suite.T(), []string{"resetUrl is required"}, err.(*InvalidParametersError).Violations)
+	}
+
+	params, err := repo.Templates["reset:en"].Schema.Apply("reset", map[string]interface{}{"resetUrl": "https://example.com/reset"})
+	if assert.NoError(suite.T(), err) {
+		assert.Equal(suite.T(), "there", params["name"])
+	}
+
+	_, _, _, err = app.(*application).renderAll(repo.Templates["reset:en"], &Job{})
+	assert.NoError(suite.T(), err, "Templates without references to missing parameters should render")
+
+	tpl := repo.Templates["reset:en"]
+	tpl.HtmlBody = "{{ .resetUrl }}"
+
+	_, _, _, err = app.(*application).renderAll(tpl, &Job{})
+	assert.Error(suite.T(), err, "Missing parameters should fail to render for templates with a schema")
+}
+
+func (suite *applicationTestSuite) TestOptionalParameters() {
+	repo := &templateRepository{Templates: map[string]Template{
+		"order:en": {
+			TemplateId: "order",
+			Locale:     "en",
+			Enabled:    true,
+			HtmlBody:   "Thanks{{if .coupon}}, use {{.coupon}} next time{{end}}",
+			Schema: ParameterSchema{
+				{Name: "coupon", Type: ParameterString},
+			},
+		},
+	}}
+
+	email := &transport{Sent: make(chan *Job, 2), Bodies: make(chan string, 2)}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(repo),
+		SetDefaultEmailTransport(email),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	if !assert.NoError(suite.T(), app.SendEmail("order", "en", "test@example.com", "", map[string]interface{}{})) {
+		return
+	}
+
+	select {
+	case body := <-email.Bodies:
+		assert.Equal(suite.T(), "Thanks", body, "Optional parameters should be usable in conditions when missing")
+
+	case <-time.After(time.Second):
+		suite.T().Error("The job was not sent")
+	}
+
+	if !assert.NoError(suite.T(), app.SendEmail("order", "en", "test@example.com", "", map[string]interface{}{"coupon": "SAVE10"})) {
+		return
+	}
+
+	select {
+	case body := <-email.Bodies:
+		assert.Equal(suite.T(), "Thanks, use SAVE10 next time", body)
+
+	case <-time.After(time.Second):
+		suite.T().Error("The job was not sent")
+	}
+}
+
+func (suite *applicationTestSuite) TestTemplateSenders() {
+	fallback := "Acme <noreply@acme.com>"
+
+	assert.Equal(suite.T(), fallback, Template{}.EmailFrom(fallback))
+	assert.Equal(suite.T(), `"Support" <noreply@acme.com>`, Template{FromName: "Support"}.EmailFrom(fallback))
+	assert.Equal(suite.T(), `"Acme" <support@acme.com>`, Template{FromAddress: "support@acme.com"}.EmailFrom(fallback))
+	assert.Equal(suite.T(), `"Support" <support@acme.com>`, Template{FromName: "Support", FromAddress: "support@acme.com"}.EmailFrom(fallback))
+	assert.Equal(suite.T(), "not an address", Template{FromName: "Support"}.EmailFrom("not an address"))
+
+	assert.Equal(suite.T(), "noreply@acme.com", Template{}.EmailReplyTo("noreply@acme.com"))
+	assert.Equal(suite.T(), "support@acme.com", Template{ReplyTo: "support@acme.com"}.EmailReplyTo("noreply@acme.com"))
+
+	assert.Equal(suite.T(), "Acme", Template{}.SmsFrom("Acme"))
+	assert.Equal(suite.T(), "AcmeSupport", Template{SmsSender: "AcmeSupport"}.SmsFrom("Acme"))
+
+	for _, sender := range []string{"", "Acme", "Acme Shop", "+46700000000", "46700000000"} {
+		assert.NoError(suite.T(), validateSmsSender(sender), sender)
+	}
+
+	for _, sender := range []string{"AcmeSupport1", "Acmeå", "+Acme", "+", "1234567890123456", "Acme\n"} {
+		assert.Error(suite.T(), validateSmsSender(sender), sender)
+	}
+
+	templates := &templateRepository{
+		Templates: map[string]Template{
+			"welcome:en": {TemplateId: "welcome", Locale: "en", Subject: "Welcome"},
+		},
+	}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(templates),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	request := func(body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+
+		return mux.SetURLVars(r, map[string]string{"id": "en:welcome"})
+	}
+
+	w := httptest.NewRecorder()
+	app.HttpHandler().UpdateTemplate(w, request(`{"subject": "Welcome", "smsSender": "AcmeSupport1"}`))
+	assert.Equal(suite.T(), 422, w.Code, "Sender ids longer than 11 characters should be rejected")
+
+	w = httptest.NewRecorder()
+	app.HttpHandler().UpdateTemplate(w, request(`{"subject": "Welcome", "smsSender": "Acme"}`))
+
+	if assert.Equal(suite.T(), 200, w.Code) {
+		assert.Equal(suite.T(), "Acme", templates.Templates["welcome:en"].SmsSender)
+	}
+
+	// Created templates are validated by the same rules as updated templates
+	for _, body := range []string{
+		`{"id": "reset", "locale": "en", "subject": "Reset", "fromAddress": "not an address"}`,
+		`{"id": "reset", "locale": "en", "subject": "Reset", "smsSender": "AcmeSupport1"}`,
+		`{"id": "reset", "locale": "en", "subject": "Reset {{.name"}`,
+	} {
+		w = httptest.NewRecorder()
+		app.HttpHandler().CreateTemplate(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		assert.Equal(suite.T(), 422, w.Code, body)
+	}
+
+	assert.NotContains(suite.T(), templates.Templates, "reset:en")
+}
+
+func (suite *applicationTestSuite) TestValidateRecipients() {
+	valid := []Job{
+		{Type: JobEmail, Target: "anna@example.com"},
+		{Type: JobEmail, Target: "Anna <anna@example.com>", To: []string{"erik@example.com"}, Cc: []string{"lisa@example.com"}, Bcc: []string{"audit@example.com"}},
+		{Type: JobSms, Target: "+46700000000"},
+	}
+
+	for _, job := range valid {
+		assert.NoError(suite.T(), job.validateRecipients(), job.Target)
+	}
+
+	invalid := []Job{
+		{Type: JobEmail, Target: "not an address"},
+		{Type: JobEmail, Target: "anna@example.com", To: []string{"erik"}},
+		{Type: JobEmail, Target: "anna@example.com", Cc: []string{""}},
+		{Type: JobEmail, Target: "anna@example.com", Bcc: []string{"audit@"}},
+		{Type: JobSms, Target: "+46700000000", Cc: []string{"lisa@example.com"}},
+		{Type: JobInbox, Target: "anna", To: []string{"erik"}},
+	}
+
+	for _, job := range invalid {
+		assert.Error(suite.T(), job.validateRecipients(), job.Target)
+	}
+
+	email := &transport{Sent: make(chan *Job, 1)}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(&templateRepository{GetTemplate: Template{Enabled: true}}),
+		SetDefaultEmailTransport(email),
+		SetDefaultSmsTransport(&transport{}),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	err = app.SendEmail("welcome", "en", "anna@example.com", "", nil, WithTo("erik@example.com"), WithCc("lisa@example.com"), WithBcc("audit@example.com"))
+	if !assert.NoError(suite.T(), err) {
+		return
+	}
+
+	select {
+	case job := <-email.Sent:
+		assert.Equal(suite.T(), []string{"anna@example.com", "erik@example.com"}, job.Recipients())
+		assert.Equal(suite.T(), []string{"lisa@example.com"}, job.Cc)
+		assert.Equal(suite.T(), []string{"audit@example.com"}, job.Bcc)
+
+	case <-time.After(time.Second):
+		suite.T().Error("Job was never sent")
+	}
+
+	assert.Error(suite.T(), app.SendSms("welcome", "en", "+46700000000", "", nil, WithCc("lisa@example.com")))
+}
+
+func (suite *applicationTestSuite) TestValidateHeadersAndMetadata() {
+	valid := []Job{
+		{Type: JobEmail, Headers: map[string]string{"X-Campaign": "spring", "List-Unsubscribe": "<mailto:unsubscribe@example.com>"}},
+		{Type: JobSms},
+	}
+
+	for _, job := range valid {
+		assert.NoError(suite.T(), job.validateHeaders())
+	}
+
+	invalid := []map[string]string{
+		{"": "empty"},
+		{"X Campaign": "space"},
+		{"X-Campaign:": "colon"},
+		{"X-Kampänj": "non ascii"},
+		{"subject": "reserved"},
+		{"Content-Type": "text/plain"},
+		{"X-Campaign": "spring\r\nBcc: eve@example.com"},
+	}
+
+	for _, headers := range invalid {
+		assert.Error(suite.T(), (&Job{Type: JobEmail, Headers: headers}).validateHeaders(), "%v", headers)
+	}
+
+	assert.Error(suite.T(), (&Job{Type: JobSms, Headers: map[string]string{"X-Campaign": "spring"}}).validateHeaders())
+
+	assert.Equal(suite.T(), "order_id-1", SanitizeTag("order.id-1"))
+	assert.Equal(suite.T(), "anna_example_com", SanitizeTag("anna@example.com"))
+	assert.Len(suite.T(), SanitizeTag(strings.Repeat("a", 300)), 256)
+
+	assert.NoError(suite.T(), (&Job{Tags: []string{"receipt"}, Metadata: map[string]string{"orderId": "1", "order-id": "1"}}).validateMetadata())
+
+	collisions := []Job{
+		{Metadata: map[string]string{"order.id": "1", "order_id": "2"}},
+		{Metadata: map[string]string{"template": "welcome"}},
+		{Tags: []string{"black friday"}, Metadata: map[string]string{"black_friday": "true"}},
+		{Tags: []string{"receipt", "receipt"}},
+		{Metadata: map[string]string{"": "empty"}},
+	}
+
+	for _, job := range collisions {
+		assert.Error(suite.T(), job.validateMetadata(), "%v %v", job.Tags, job.Metadata)
+	}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(&templateRepository{GetTemplate: Template{Enabled: true}}),
+		SetDefaultEmailTransport(&transport{Sent: make(chan *Job, 1)}),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	err = app.SendEmail("welcome", "en", "anna@example.com", "", nil, WithMetadata("order.id", "1"), WithMetadata("order id", "2"))
+	assert.Error(suite.T(), err, "Metadata keys sanitized to the same tag name should be rejected")
+}
+
+func (suite *applicationTestSuite) TestRecordParametersKeepsNewerChanges() {
+	templates := &templateRepository{
+		Templates: map[string]Template{
+			"welcome:en": {TemplateId: "welcome", Locale: "en", UpdateParameters: true, Version: 2, Subject: "Edited"},
+		},
+	}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(templates),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	cached := Template{TemplateId: "welcome", Locale: "en", UpdateParameters: true, Version: 1, Subject: "Placeholder"}
+	params := map[string]interface{}{"name": "Anna"}
+
+	if !assert.NoError(suite.T(), app.(*application).recordParameters(cached, params)) {
+		return
+	}
+
+	tpl := templates.Templates["welcome:en"]
+	assert.Equal(suite.T(), "Edited", tpl.Subject, "A cached template should not overwrite newer changes")
+	assert.Equal(suite.T(), 2, tpl.Version)
+	assert.Equal(suite.T(), params, tpl.Parameters)
+	assert.False(suite.T(), tpl.UpdateParameters)
+
+	// Parameters already recorded by another job are kept
+	assert.NoError(suite.T(), app.(*application).recordParameters(cached, map[string]interface{}{"other": true}))
+	assert.Equal(suite.T(), params, templates.Templates["welcome:en"].Parameters)
+}
+
+func (suite *applicationTestSuite) TestAttachments() {
+	email := &transport{Sent: make(chan *Job, 1)}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(&templateRepository{GetTemplate: Template{Enabled: true}}),
+		SetDefaultEmailTransport(email),
+		SetDefaultSmsTransport(&transport{}),
+		SetAttachmentLoader(func(ctx context.Context, reference string) ([]byte, error) {
+			if reference == "missing" {
+				return nil, errors.New("not found")
+			}
+
+			return []byte("content of " + reference), nil
+		}),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	err = app.SendEmail("invoice", "en", "test@example.com", "", nil, WithAttachments(
+		Attachment{Name: "invoice.pdf", ContentType: "application/pdf", Reference: "s3://invoices/1.pdf"},
+		Attachment{Name: "logo.png", ContentType: "image/png", Inline: true, Content: []byte("png")},
+	))
+
+	if !assert.NoError(suite.T(), err) {
+		return
+	}
+
+	select {
+	case job := <-email.Sent:
+		content, err := job.Attachments[0].Load(context.Background())
+		assert.NoError(suite.T(), err)
+		assert.Equal(suite.T(), "content of s3://invoices/1.pdf", string(content))
+
+		content, err = job.Attachments[1].Load(context.Background())
+		assert.NoError(suite.T(), err)
+		assert.Equal(suite.T(), "png", string(content))
+
+		job.Attachments[0].Reference = "missing"
+		_, err = job.Attachments[0].Load(context.Background())
+		assert.Error(suite.T(), err)
+
+	case <-time.After(time.Second):
+		suite.T().Error("Job was never sent")
+	}
+
+	_, err = Attachment{Name: "invoice.pdf", Reference: "s3://invoices/1.pdf"}.Load(context.Background())
+	assert.Error(suite.T(), err, "References can not be loaded without a loader")
+
+	for _, attachment := range []Attachment{
+		{},
+		{Name: "invoice.pdf\r\nBcc: eve@example.com"},
+		{Name: "invoice.pdf", ContentType: "application/pdf\r\nX-Injected: true"},
+		{Name: "invoice.pdf", ContentType: "not a type"},
+		{Name: "<logo>", Inline: true},
+	} {
+		assert.Error(suite.T(), app.SendEmail("invoice", "en", "test@example.com", "", nil, WithAttachments(attachment)), attachment.Name)
+	}
+
+	err = app.SendSms("invoice", "en", "+46700000000", "", nil, WithAttachments(Attachment{Name: "invoice.pdf", Content: []byte("pdf")}))
+	assert.Error(suite.T(), err, "Attachments should only be supported for emails")
+}
+
+func (suite *applicationTestSuite) TestFallbackEscalatesOnError() {
+	sms := &transport{Err: errors.New("provider down"), Sent: make(chan *Job, 1)}
+	email := &transport{Sent: make(chan *Job, 1)}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(&templateRepository{GetTemplate: Template{Enabled: true}}),
+		SetDefaultSmsTransport(sms),
+		SetDefaultEmailTransport(email),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	err = app.SendWithFallback("otp", "en", "", nil, []FallbackStep{
+		{Type: JobSms, Target: "+46700000000", OnError: true},
+		{Type: JobEmail, Target: "test@example.com"},
+	})
+
+	if !assert.NoError(suite.T(), err, "Failed to send with fallback") {
+		return
+	}
+
+	select {
+	case job := <-email.Sent:
+		assert.Equal(suite.T(), "test@example.com", job.Target)
+		assert.Equal(suite.T(), 1, job.ChainStep)
+		assert.NotNil(suite.T(), job.ParentUuid)
+
+	case <-time.After(time.Second):
+		suite.T().Error("Fallback job was never sent")
+	}
+}
+
+func (suite *applicationTestSuite) TestFallbackResumesEscalation() {
+	email := &transport{Sent: make(chan *Job, 1)}
+
+	chainUuid := uuid.New()
+	deadline := time.Now().Add(-time.Minute)
+
+	sent := Job{
+		Uuid:       uuid.New(),
+		Type:       JobSms,
+		TemplateId: "otp",
+		Target:     "+46700000000",
+		ChainUuid:  &chainUuid,
+		Chain: []FallbackStep{
+			{Type: JobSms, Target: "+46700000000", Timeout: time.Minute},
+			{Type: JobEmail, Target: "test@example.com"},
+		},
+		SentAt:     &deadline,
+		EscalateAt: &deadline,
+	}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{MatchingJobs: []Job{sent}}),
+		SetTemplateRepo(&templateRepository{GetTemplate: Template{Enabled: true}}),
+		SetDefaultSmsTransport(&transport{}),
+		SetDefaultEmailTransport(email),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	select {
+	case job := <-email.Sent:
+		assert.Equal(suite.T(), "test@example.com", job.Target)
+		assert.Equal(suite.T(), sent.Uuid, *job.ParentUuid)
+
+	case <-time.After(time.Second):
+		suite.T().Error("Pending escalation was not resumed at startup")
+	}
+
+	err = app.SendWithFallback("otp", "en", "", nil, []FallbackStep{
+		{Type: JobSms, Target: "+46700000000", OnError: true},
+		{Type: JobEmail, Target: "not an address"},
+	})
+	assert.Error(suite.T(), err, "Email steps should require a valid address")
+
+	err = app.SendWithFallback("otp", "en", "", nil, []FallbackStep{
+		{Type: JobSms, Target: "+46700000000"},
+	}, WithHeader("X-Campaign", "spring"))
+	assert.Error(suite.T(), err, "Headers should be rejected without an email step")
+
+	err = app.SendWithFallback("otp", "en", "", nil, []FallbackStep{
+		{Type: JobSms, Target: "+46700000000", OnError: true},
+		{Type: JobEmail, Target: "test@example.com"},
+	}, WithHeader("Subject", "Overridden"))
+	assert.Error(suite.T(), err, "Reserved headers should be rejected")
+}
+
+func (suite *applicationTestSuite) TestFailoverRecordsProvider() {
+	primary := &transport{Err: errors.New("provider down")}
+	secondary := &transport{Sent: make(chan *Job, 2)}
+
+	failover := NewFailoverTransport([]FailoverProvider{
+		{Name: "primary", Transport: primary},
+		{Name: "secondary", Transport: secondary},
+	}, SetFailoverThreshold(1), SetFailoverCooldown(time.Hour))
+
+	for i := 0; i < 2; i++ {
+		job := &Job{}
+
+		if !assert.NoError(suite.T(), failover.Send(context.Background(), job, Template{}, nil)) {
+			return
+		}
+
+		assert.Equal(suite.T(), "secondary", job.Provider)
+	}
+
+	assert.Equal(suite.T(), 1, primary.Calls, "Primary should be skipped while its circuit is open")
+
+	// Broken templates fail without opening the circuit of the providers
+	healthy := &transport{Sent: make(chan *Job, 1), Bodies: make(chan string, 1)}
+	backup := &transport{Sent: make(chan *Job, 1), Bodies: make(chan string, 1)}
+
+	failover = NewFailoverTransport([]FailoverProvider{
+		{Name: "healthy", Transport: healthy},
+		{Name: "backup", Transport: backup},
+	}, SetFailoverThreshold(1), SetFailoverCooldown(time.Hour))
+
+	broken := func(field TemplateField, params map[string]interface{}) (string, error) {
+		return "", errors.New("map has no entry for key")
+	}
+
+	assert.Error(suite.T(), failover.Send(context.Background(), &Job{}, Template{}, broken))
+	assert.Equal(suite.T(), 0, backup.Calls, "Render errors should not fail over")
+
+	job := &Job{}
+	working := func(field TemplateField, params map[string]interface{}) (string, error) {
+		return "Hello", nil
+	}
+
+	if assert.NoError(suite.T(), failover.Send(context.Background(), job, Template{}, working)) {
+		assert.Equal(suite.T(), "healthy", job.Provider, "Render errors should leave the circuit closed")
+	}
+}
+
+func (suite *applicationTestSuite) TestRoutingByCallingCodeAndTemplate() {
+	elks := &transport{}
+	twilio := &transport{}
+	marketing := &transport{}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(&templateRepository{}),
+		SetDefaultSmsTransport(twilio),
+		AddRoute(Route{Type: JobSms, CallingCodes: []string{"46"}, Transport: elks}),
+		AddRoute(Route{Type: JobEmail, TemplateIds: []string{"marketing-*"}, Transport: marketing}),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	a := app.(*application)
+
+	assert.True(suite.T(), a.transportFor(&Job{Type: JobSms, Target: "+46 70 123 45 67"}) == elks)
+	assert.True(suite.T(), a.transportFor(&Job{Type: JobSms, Target: "0046701234567"}) == elks)
+	assert.True(suite.T(), a.transportFor(&Job{Type: JobSms, Target: "+4740123456"}) == twilio)
+	assert.True(suite.T(), a.transportFor(&Job{Type: JobEmail, TemplateId: "marketing-spring"}) == marketing)
+	assert.True(suite.T(), a.transportFor(&Job{Type: JobEmail, TemplateId: "welcome"}) == nil)
+	assert.True(suite.T(), a.hasTransport(JobEmail))
+}
+
+func (suite *applicationTestSuite) TestLocaleChain() {
+	repo := &templateRepository{
+		Templates: map[string]Template{
+			"welcome:sv":    {TemplateId: "welcome", Locale: "sv", Enabled: false},
+			"welcome:en-GB": {TemplateId: "welcome", Locale: "en-GB", Enabled: true},
+			"welcome:en":    {TemplateId: "welcome", Locale: "en", Enabled: true},
+		},
+	}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(repo),
+		SetFallbackLocale("en"),
+		SetLocaleFallbacks(map[string][]string{"sv": {"en-GB"}}),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	a := app.(*application)
+
+	assert.Equal(suite.T(), []string{"sv-FI", "sv", "en-GB", "en"}, a.localeChain("sv-FI"))
+	assert.Equal(suite.T(), []string{"en"}, a.localeChain("en"))
+
+	tpl, err := a.getTemplate("welcome", "sv-FI")
+	if assert.NoError(suite.T(), err) {
+		assert.Equal(suite.T(), "en-GB", tpl.Locale, "Disabled sv template should be skipped")
+	}
+}
+
+func (suite *applicationTestSuite) TestMissingTemplatePolicy() {
+	email := &transport{Sent: make(chan *Job, 1)}
+	templates := &templateRepository{Templates: map[string]Template{}}
+	jobs := &jobRepository{}
+
+	app, err := NewApplication(
+		SetJobRepo(jobs),
+		SetTemplateRepo(templates),
+		SetDefaultEmailTransport(email),
+		SetFallbackLocale("en"),
+		SetMissingTemplatePolicy(MissingTemplateHold),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	a := app.(*application)
+
+	job := &Job{Uuid: uuid.New(), Type: JobEmail, TemplateId: "welcome", Locale: "sv", Target: "test@example.com"}
+
+	assert.Equal(suite.T(), jobHeldErr, a.process(job))
+	assert.Equal(suite.T(), 0, email.Calls, "Placeholder should not be sent")
+
+	a.holdJob(job)
+	assert.NotNil(suite.T(), job.HeldAt)
+
+	jobs.MatchingJobs = []Job{*job}
+	templates.Templates["welcome:sv"] = Template{TemplateId: "welcome", Locale: "sv", Enabled: true, Subject: "Welcome"}
+	a.invalidateTemplate("welcome", "sv")
+
+	if !assert.NoError(suite.T(), a.releaseHeldJobs("welcome")) {
+		return
+	}
+
+	select {
+	case sent := <-email.Sent:
+		assert.Equal(suite.T(), job.Uuid, sent.Uuid)
+		assert.Nil(suite.T(), sent.HeldAt)
+
+	case <-time.After(time.Second):
+		suite.Fail("Released job was not sent")
+	}
+
+	a.missingTemplatePolicy = MissingTemplateFail
+	err = a.process(&Job{Type: JobEmail, TemplateId: "goodbye", Locale: "sv"})
+	assert.Equal(suite.T(), TemplateNotFoundErr, errors.Cause(err))
+}
+
+func (suite *applicationTestSuite) TestTemplateVersionRollback() {
+	templates := &templateRepository{
+		Templates: map[string]Template{
+			"welcome:en": {TemplateId: "welcome", Locale: "en", Enabled: true, Subject: "Welcome"},
+		},
+	}
+	versions := &templateVersionRepository{}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(templates),
+		SetTemplateVersionRepo(versions),
+		SetAuthorResolver(func(r *http.Request) string {
+			return r.Header.Get("X-User")
+		}),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	handler := app.HttpHandler()
+
+	r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"enabled": true, "subject": "Broken {{"}`))
+	r = mux.SetURLVars(r, map[string]string{"id": "en:welcome"})
+
+	w := httptest.NewRecorder()
+	handler.UpdateTemplate(w, r)
+	assert.Equal(suite.T(), 422, w.Code, "Invalid templates should not be stored")
+
+	r = httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"enabled": true, "subject": "Hello"}`))
+	r = mux.SetURLVars(r, map[string]string{"id": "en:welcome"})
+	r.Header.Set("X-User", "jane")
+
+	w = httptest.NewRecorder()
+	handler.UpdateTemplate(w, r)
+
+	if !assert.Equal(suite.T(), 200, w.Code) || !assert.Len(suite.T(), versions.Versions, 2) {
+		return
+	}
+
+	assert.Equal(suite.T(), "Welcome", versions.Versions[0].Subject, "Content before versioning should be kept")
+	assert.Equal(suite.T(), 1, versions.Versions[1].Version)
+	assert.Equal(suite.T(), "jane", versions.Versions[1].Author)
+
+	r = httptest.NewRequest(http.MethodPost, "/", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": "en:welcome", "version": "0"})
+
+	w = httptest.NewRecorder()
+	handler.RollbackTemplate(w, r)
+
+	if !assert.Equal(suite.T(), 200, w.Code) {
+		return
+	}
+
+	tpl := templates.Templates["welcome:en"]
+	assert.Equal(suite.T(), "Welcome", tpl.Subject)
+	assert.Equal(suite.T(), 2, tpl.Version)
+
+	if assert.Len(suite.T(), versions.Versions, 3) && assert.NotNil(suite.T(), versions.Versions[2].RestoredFrom) {
+		assert.Equal(suite.T(), 0, *versions.Versions[2].RestoredFrom)
+	}
+
+	diff := func(version, against string) (int, []FieldChange) {
+		r := httptest.NewRequest(http.MethodGet, "/?against="+against, nil)
+		r = mux.SetURLVars(r, map[string]string{"id": "en:welcome", "version": version})
+
+		w := httptest.NewRecorder()
+		handler.DiffTemplateVersion(w, r)
+
+		var changes []FieldChange
+		json.Unmarshal(w.Body.Bytes(), &changes)
+
+		return w.Code, changes
+	}
+
+	code, changes := diff("1", "")
+	if assert.Equal(suite.T(), 200, code) {
+		assert.Equal(suite.T(), []FieldChange{{Field: "subject", From: "Welcome", To: "Hello"}}, changes)
+	}
+
+	code, changes = diff("2", "0")
+	if assert.Equal(suite.T(), 200, code) {
+		assert.Empty(suite.T(), changes, "The rollback should restore version 0")
+	}
+
+	code, _ = diff("2", "7")
+	assert.Equal(suite.T(), 404, code)
+
+	// Versions stored before validation changed may no longer render
+	versions.Versions = append(versions.Versions, TemplateVersion{TemplateId: "welcome", Locale: "en", Version: 9, Subject: "Broken {{"})
+
+	r = httptest.NewRequest(http.MethodPost, "/", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": "en:welcome", "version": "9"})
+
+	w = httptest.NewRecorder()
+	handler.RollbackTemplate(w, r)
+	assert.Equal(suite.T(), 422, w.Code)
+	assert.Equal(suite.T(), 2, templates.Templates["welcome:en"].Version)
+
+	versions.Err = errors.New("connection refused")
+
+	r = httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"enabled": true, "subject": "Unversioned"}`))
+	r = mux.SetURLVars(r, map[string]string{"id": "en:welcome"})
+
+	w = httptest.NewRecorder()
+	handler.UpdateTemplate(w, r)
+	assert.Equal(suite.T(), 500, w.Code, "Changes should not be stored without their version")
+	assert.Equal(suite.T(), "Welcome", templates.Templates["welcome:en"].Subject)
+}
+
+func (suite *applicationTestSuite) TestDraftRequiresApproval() {
+	templates := &templateRepository{
+		Templates: map[string]Template{
+			"welcome:en": {TemplateId: "welcome", Locale: "en", Enabled: true, Version: 3, Subject: "Welcome"},
+		},
+	}
+	versions := &templateVersionRepository{}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(templates),
+		SetTemplateVersionRepo(versions),
+		SetDraftWorkflow(true),
+		SetAuthorResolver(func(r *http.Request) string {
+			return r.Header.Get("X-User")
+		}),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	handler := app.HttpHandler()
+
+	request := func(body, user string) *http.Request {
+		r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+		r.Header.Set("X-User", user)
+
+		return mux.SetURLVars(r, map[string]string{"id": "en:welcome"})
+	}
+
+	w := httptest.NewRecorder()
+	handler.UpdateTemplate(w, request(`{"enabled": true, "subject": "Hello"}`, "jane"))
+
+	if !assert.Equal(suite.T(), 200, w.Code) {
+		return
+	}
+
+	tpl := templates.Templates["welcome:en"]
+	assert.Equal(suite.T(), "Welcome", tpl.Subject, "Drafts should not change the published content")
+	assert.Equal(suite.T(), 3, tpl.Version)
+	assert.Empty(suite.T(), versions.Versions)
+
+	w = httptest.NewRecorder()
+	handler.PublishTemplateDraft(w, request("", "jane"))
+	assert.Equal(suite.T(), 403, w.Code, "Authors should not be able to approve their own drafts")
+
+	w = httptest.NewRecorder()
+	handler.PublishTemplateDraft(w, request("", "john"))
+
+	if !assert.Equal(suite.T(), 200, w.Code) {
+		return
+	}
+
+	tpl = templates.Templates["welcome:en"]
+	assert.Equal(suite.T(), "Hello", tpl.Subject)
+	assert.Equal(suite.T(), 4, tpl.Version)
+	assert.Nil(suite.T(), tpl.Draft)
+
+	if assert.Len(suite.T(), versions.Versions, 1) {
+		assert.Equal(suite.T(), "jane", versions.Versions[0].Author)
+		assert.Equal(suite.T(), "john", versions.Versions[0].ApprovedBy)
+	}
+
+	// New templates are created as drafts as well
+	create := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"id": "news", "locale": "en", "enabled": true, "subject": "News"}`))
+	create.Header.Set("X-User", "jane")
+
+	w = httptest.NewRecorder()
+	handler.CreateTemplate(w, create)
+
+	if !assert.Equal(suite.T(), 201, w.Code) {
+		return
+	}
+
+	tpl = templates.Templates["news:en"]
+	assert.False(suite.T(), tpl.Enabled, "New templates should not be enabled before they are published")
+	assert.Empty(suite.T(), tpl.Subject)
+	assert.Equal(suite.T(), 0, tpl.Version)
+	assert.Len(suite.T(), versions.Versions, 1)
+
+	publish := func(user string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("X-User", user)
+
+		return mux.SetURLVars(r, map[string]string{"id": "en:news"})
+	}
+
+	w = httptest.NewRecorder()
+	handler.PublishTemplateDraft(w, publish("jane"))
+	assert.Equal(suite.T(), 403, w.Code, "Authors should not be able to approve their own new templates")
+
+	w = httptest.NewRecorder()
+	handler.PublishTemplateDraft(w, publish("john"))
+
+	if !assert.Equal(suite.T(), 200, w.Code) {
+		return
+	}
+
+	tpl = templates.Templates["news:en"]
+	assert.True(suite.T(), tpl.Enabled)
+	assert.Equal(suite.T(), "News", tpl.Subject)
+	assert.Equal(suite.T(), 1, tpl.Version)
+
+	if assert.Len(suite.T(), versions.Versions, 2, "Templates created as drafts should not get an empty baseline") {
+		assert.Equal(suite.T(), 1, versions.Versions[1].Version)
+		assert.Equal(suite.T(), "john", versions.Versions[1].ApprovedBy)
+	}
+}
+
+func (suite *applicationTestSuite) TestTestSendTemplate() {
+	templates := &templateRepository{
+		Templates: map[string]Template{
+			"welcome:en": {TemplateId: "welcome", Locale: "en", Version: 1, Subject: "Welcome", Draft: &TemplateVersion{Version: 2, Subject: "Hello"}},
+			"welcome:sv": {TemplateId: "welcome", Locale: "sv", Enabled: true, Version: 1, Subject: "Välkommen"},
+		},
+	}
+
+	email := &transport{Sent: make(chan *Job, 1)}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(templates),
+		SetDefaultEmailTransport(email),
+		SetFallbackLocale("sv"),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	request := func(body string) *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	}
+
+	w := httptest.NewRecorder()
+	app.HttpHandler().TestTemplate(w, request(`{"id": "en:welcome", "type": "email", "target": "test@example.com", "draft": true}`))
+
+	if !assert.Equal(suite.T(), 204, w.Code) {
+		return
+	}
+
+	select {
+	case job := <-email.Sent:
+		assert.Equal(suite.T(), "en", job.ResolvedLocale, "Drafts of disabled templates should be sent in the requested locale")
+
+	case <-time.After(time.Second):
+		suite.T().Error("The test send was not sent")
+	}
+
+	w = httptest.NewRecorder()
+	app.HttpHandler().TestTemplate(w, request(`{"id": "en:welcome", "type": "email", "target": "not an address", "draft": true}`))
+	assert.Equal(suite.T(), 422, w.Code, "Invalid test sends should be reported")
+
+	w = httptest.NewRecorder()
+	app.HttpHandler().TestTemplate(w, request(`{"id": "en:welcome", "type": "sms", "target": "+46700000000"}`))
+	assert.Equal(suite.T(), 500, w.Code, "Missing transports should be reported")
+
+	w = httptest.NewRecorder()
+	app.HttpHandler().TestTemplate(w, request(`{"id": "en:missing", "type": "email", "target": "test@example.com"}`))
+	assert.Equal(suite.T(), 404, w.Code)
+}
+
+func (suite *applicationTestSuite) TestDraftEnablesOnPublish() {
+	templates := &templateRepository{
+		Templates: map[string]Template{
+			"welcome:en": {TemplateId: "welcome", Locale: "en", Subject: placeholderSubject},
+		},
+	}
+
+	heldAt := time.Now()
+	held := Job{Uuid: uuid.New(), Type: JobEmail, TemplateId: "welcome", Locale: "en", Target: "test@example.com", HeldAt: &heldAt}
+	email := &transport{Sent: make(chan *Job, 1)}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{MatchingJobs: []Job{held}}),
+		SetTemplateRepo(templates),
+		SetDefaultEmailTransport(email),
+		SetMissingTemplatePolicy(MissingTemplateHold),
+		SetDraftWorkflow(false),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	request := func(body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+
+		return mux.SetURLVars(r, map[string]string{"id": "en:welcome"})
+	}
+
+	w := httptest.NewRecorder()
+	app.HttpHandler().UpdateTemplate(w, request(`{"enabled": true, "subject": "Welcome"}`))
+
+	if !assert.Equal(suite.T(), 200, w.Code) {
+		return
+	}
+
+	assert.False(suite.T(), templates.Templates["welcome:en"].Enabled, "Drafts should not enable the placeholder")
+
+	select {
+	case <-email.Sent:
+		suite.T().Error("Held jobs should not be released by drafts")
+
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	w = httptest.NewRecorder()
+	app.HttpHandler().PublishTemplateDraft(w, request(""))
+
+	if !assert.Equal(suite.T(), 200, w.Code) {
+		return
+	}
+
+	tpl := templates.Templates["welcome:en"]
+	assert.True(suite.T(), tpl.Enabled)
+	assert.Equal(suite.T(), "Welcome", tpl.Subject)
+
+	select {
+	case job := <-email.Sent:
+		assert.Equal(suite.T(), held.Uuid, job.Uuid)
+
+	case <-time.After(time.Second):
+		suite.T().Error("Held jobs should be released when the draft is published")
+	}
+}
+
+func (suite *applicationTestSuite) TestPreviewTemplate() {
+	templates := &templateRepository{
+		Templates: map[string]Template{
+			"code:sv": {TemplateId: "code", Locale: "sv", Enabled: true, Subject: "Kod", TextBody: "Din kod är {{.code}}"},
+		},
+	}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(templates),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	preview := func(id, body string) map[string]interface{} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"id": id})
+
+		w := httptest.NewRecorder()
+		app.HttpHandler().PreviewTemplate(w, r)
+
+		if !assert.Equal(suite.T(), 200, w.Code, w.Body.String()) {
+			return nil
+		}
+
+		var payload map[string]interface{}
+		assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), &payload))
+
+		return payload
+	}
+
+	if payload := preview("sv:code", `{"parameters": {"code": 1234}}`); payload != nil {
+		assert.Equal(suite.T(), "Din kod är 1234", payload["textBody"])
+		assert.Equal(suite.T(), "GSM-7", payload["sms"].(map[string]interface{})["encoding"])
+	}
+
+	if payload := preview("en:code", `{"template": {"textBody": "Your code 🔑 {{.code}}"}, "parameters": {"code": 1}}`); payload != nil {
+		assert.Equal(suite.T(), "Your code 🔑 1", payload["textBody"])
+		assert.Equal(suite.T(), "UCS-2", payload["sms"].(map[string]interface{})["encoding"])
+	}
+
+	assert.Equal(suite.T(), 2, AnalyzeSms(strings.Repeat("a", 159)+"€").Segments, "Extension characters count twice")
+	assert.Equal(suite.T(), 1, AnalyzeSms(strings.Repeat("å", 160)).Segments)
+	assert.Equal(suite.T(), 3, AnalyzeSms(strings.Repeat("ж", 135)).Segments)
+}
+
+func (suite *applicationTestSuite) TestRenderService() {
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(&templateRepository{
+			Templates: map[string]Template{
+				"banner:en": {TemplateId: "banner", Locale: "en", Enabled: true, Subject: "Hi {{.name}}", HtmlBody: "<b>{{.name}}</b>"},
+			},
+		}),
+		SetFallbackLocale("en"),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	rendered, err := app.Render("banner", "en-US", map[string]interface{}{"name": "<Anna>"})
+	if assert.NoError(suite.T(), err) {
+		assert.Equal(suite.T(), "en", rendered.Locale)
+		assert.Equal(suite.T(), "Hi <Anna>", rendered.Subject)
+		assert.Equal(suite.T(), "<b>&lt;Anna&gt;</b>", rendered.HtmlBody)
+	}
+
+	_, err = app.Render("missing", "en", nil)
+	assert.Equal(suite.T(), TemplateNotFoundErr, errors.Cause(err))
+
+	// Previews resolve templates like jobs, so the fail policy does not fall back to other languages
+	email := &transport{Sent: make(chan *Job, 1)}
+
+	app, err = NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(&templateRepository{
+			Templates: map[string]Template{
+				"banner:en": {TemplateId: "banner", Locale: "en", Enabled: true, Subject: "Hi {{.name}}"},
+			},
+		}),
+		SetDefaultEmailTransport(email),
+		SetFallbackLocale("en"),
+		SetMissingTemplatePolicy(MissingTemplateFail),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	_, err = app.Render("banner", "en-US", map[string]interface{}{"name": "Anna"})
+	assert.NoError(suite.T(), err)
+
+	_, err = app.Render("banner", "sv", map[string]interface{}{"name": "Anna"})
+	assert.Equal(suite.T(), TemplateNotFoundErr, errors.Cause(err))
+
+	assert.NoError(suite.T(), app.SendEmail("banner", "sv", "test@example.com", "", map[string]interface{}{"name": "Anna"}))
+
+	select {
+	case <-email.Sent:
+		suite.T().Error("Jobs should not be sent in the fallback locale with the fail policy")
+
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (suite *applicationTestSuite) TestTemplateBundle() {
+	staging := &templateRepository{
+		MatchTemplates: []Template{
+			{TemplateId: "welcome", Locale: "en", Enabled: true, Subject: "Welcome {{.name}}", Parameters: map[string]interface{}{"name": "Anna"}},
+			{TemplateId: "welcome", Locale: "sv", Enabled: true, Subject: "Välkommen {{.name}}"},
+		},
+	}
+
+	bundle, err := ExportTemplates(staging, TemplateCriteria{})
+	if !assert.NoError(suite.T(), err) {
+		return
+	}
+
+	if assert.Len(suite.T(), staging.MatchCriteria, 1) {
+		assert.Equal(suite.T(), map[string]string{"template_id": "asc", "locale": "asc"}, staging.MatchCriteria[0].Sorting, "Pages should have a stable order")
+	}
+
+	out := &bytes.Buffer{}
+	if !assert.NoError(suite.T(), EncodeTemplateBundle(out, bundle, BundleFormatYaml)) {
+		return
+	}
+
+	decoded, err := DecodeTemplateBundle(out, BundleFormatYaml)
+	if !assert.NoError(suite.T(), err) || !assert.Len(suite.T(), decoded.Templates, 2) {
+		return
+	}
+
+	production := &templateRepository{
+		Templates: map[string]Template{
+			"welcome:en": {TemplateId: "welcome", Locale: "en", Enabled: true, Version: 2, Subject: "Hello {{.name}}", Parameters: map[string]interface{}{"name": "Anna"}},
+		},
+	}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(production),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	changes, err := app.ImportTemplates(decoded, true, "")
+	if !assert.NoError(suite.T(), err) || !assert.Len(suite.T(), changes, 2) {
+		return
+	}
+
+	assert.Equal(suite.T(), TemplateUpdated, changes[0].Type)
+	assert.Equal(suite.T(), []FieldChange{{Field: "subject", From: "Hello {{.name}}", To: "Welcome {{.name}}"}}, changes[0].Fields)
+	assert.Equal(suite.T(), TemplateCreated, changes[1].Type)
+	assert.Len(suite.T(), production.Templates, 1, "Dry runs should not change the repository")
+
+	_, err = app.ImportTemplates(decoded, false, "")
+	if assert.NoError(suite.T(), err) {
+		assert.Equal(suite.T(), "Welcome {{.name}}", production.Templates["welcome:en"].Subject)
+		assert.Equal(suite.T(), 3, production.Templates["welcome:en"].Version)
+		assert.True(suite.T(), production.Templates["welcome:sv"].Enabled)
+	}
+
+	// Layouts are resolved from the bundle, nothing is written when any template is invalid
+	invalid := TemplateBundle{
+		Templates: []Template{
+			{TemplateId: "base", Locale: "en", Kind: TemplateKindLayout, Enabled: true, HtmlBody: `<main>{{ template "content" . }}</main>`},
+			{TemplateId: "news", Locale: "en", Layout: "base", Subject: "News", HtmlBody: "<p>News</p>"},
+			{TemplateId: "broken", Locale: "en", Subject: "Hello {{.name"},
+		},
+	}
+
+	_, err = app.ImportTemplates(invalid, true, "")
+	if assert.IsType(suite.T(), &InvalidBundleError{}, err) {
+		assert.Equal(suite.T(), "broken", err.(*InvalidBundleError).TemplateId)
+	}
+
+	_, err = app.ImportTemplates(invalid, false, "")
+	assert.Error(suite.T(), err)
+	assert.Len(suite.T(), production.Templates, 2, "Invalid bundles should not change the repository")
+
+	invalid.Templates = invalid.Templates[:2]
+
+	changes, err = app.ImportTemplates(invalid, false, "")
+	if assert.NoError(suite.T(), err) {
+		assert.Len(suite.T(), changes, 2)
+		assert.Len(suite.T(), production.Templates, 4)
+	}
+
+	// With the draft workflow new templates are imported as drafts as well
+	reviewed := &templateRepository{Templates: map[string]Template{}}
+
+	app, err = NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(reviewed),
+		SetDraftWorkflow(false),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	_, err = app.ImportTemplates(decoded, false, "jane")
+	if assert.NoError(suite.T(), err) {
+		welcome := reviewed.Templates["welcome:en"]
+		assert.False(suite.T(), welcome.Enabled)
+		assert.Empty(suite.T(), welcome.Subject)
+
+		if assert.NotNil(suite.T(), welcome.Draft) {
+			assert.Equal(suite.T(), "Welcome {{.name}}", welcome.Draft.Subject)
+			assert.Equal(suite.T(), "jane", welcome.Draft.Author)
+			assert.True(suite.T(), welcome.Draft.Enabled)
+		}
+	}
+}
+
+func (suite *applicationTestSuite) TestSeedTemplates() {
+	files := fstest.MapFS{
+		"welcome/en/subject.txt": {Data: []byte("Welcome {{.name}}\n")},
+		"welcome/en/body.md":     {Data: []byte("# Hi {{.name}}")},
+		"welcome/en/meta.json":   {Data: []byte(`{"description": "Sent after signup"}`)},
+		"reset/en/subject.txt":   {Data: []byte("Reset your password")},
+		"receipt/en/subject.txt": {Data: []byte("Your receipt")},
+	}
+
+	templates := &templateRepository{
+		Templates: map[string]Template{
+			"reset:en": {TemplateId: "reset", Locale: "en", Subject: placeholderSubject},
+		},
+	}
+
+	newApplication := func() error {
+		_, err := NewApplication(
+			SetJobRepo(&jobRepository{}),
+			SetTemplateRepo(templates),
+			SetTemplateSeed(files, true),
+			SetHtmlToTextConverter(func(html string) string {
+				return "text: " + html
+			}),
+		)
+
+		return err
+	}
+
+	seed := func() {
+		assert.NoError(suite.T(), newApplication(), "Failed to create the new application")
+	}
+
+	seed()
+
+	welcome := templates.Templates["welcome:en"]
+	assert.Equal(suite.T(), "Welcome {{.name}}", welcome.Subject)
+	assert.Equal(suite.T(), "text: <h1>Hi {{.name}}</h1>\n", welcome.TextBody, "Html only seeds should get a text body")
+	assert.Equal(suite.T(), BodyFormatMarkdown, welcome.BodyFormat)
+	assert.Equal(suite.T(), "Sent after signup", welcome.Description)
+	assert.True(suite.T(), welcome.Enabled)
+	assert.Equal(suite.T(), "Reset your password", templates.Templates["reset:en"].Subject, "Placeholders should be replaced")
+	assert.True(suite.T(), templates.Templates["reset:en"].Enabled)
+
+	receipt := templates.Templates["receipt:en"]
+	receipt.Subject = "Edited in the administration panel"
+	templates.Templates["receipt:en"] = receipt
+
+	files["welcome/en/subject.txt"] = &fstest.MapFile{Data: []byte("Welcome aboard")}
+	files["receipt/en/subject.txt"] = &fstest.MapFile{Data: []byte("Your new receipt")}
+
+	seed()
+
+	assert.Equal(suite.T(), "Welcome aboard", templates.Templates["welcome:en"].Subject)
+	assert.Equal(suite.T(), 2, templates.Templates["welcome:en"].Version)
+	assert.Equal(suite.T(), "Edited in the administration panel", templates.Templates["receipt:en"].Subject, "Edited templates should be kept")
+
+	// Checksums only cover the seeded content
+	drafted := templates.Templates["welcome:en"]
+	drafted.Version = 5
+	drafted.Draft = &TemplateVersion{Subject: "Draft", Enabled: true}
+	assert.Equal(suite.T(), drafted.SeedChecksum, seedChecksum(drafted))
+
+	// Seeds are validated against the layouts among them before any of them is stored
+	files["news/en/subject.txt"] = &fstest.MapFile{Data: []byte("News")}
+	files["news/en/body.html"] = &fstest.MapFile{Data: []byte("<p>News</p>")}
+	files["news/en/meta.json"] = &fstest.MapFile{Data: []byte(`{"layout": "base"}`)}
+	files["base/en/body.html"] = &fstest.MapFile{Data: []byte(`<main>{{ template "content" . }}</main>`)}
+	files["base/en/meta.json"] = &fstest.MapFile{Data: []byte(`{"kind": "layout"}`)}
+	files["broken/en/subject.txt"] = &fstest.MapFile{Data: []byte("Hello {{.name")}
+
+	assert.Error(suite.T(), newApplication())
+	assert.Len(suite.T(), templates.Templates, 3, "Invalid seeds should not change the repository")
+
+	delete(files, "broken/en/subject.txt")
+
+	seed()
+
+	assert.Len(suite.T(), templates.Templates, 5)
+}
+
+func (suite *applicationTestSuite) TestTemplateHealth() {
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(&templateRepository{
+			MatchTemplates: []Template{
+				{TemplateId: "order", Locale: "en", Enabled: true, Subject: "Order {{.number}}", TextBody: "{{range .items}}{{.name}}{{end}}", Parameters: map[string]interface{}{"number": 1, "items": []interface{}{}}},
+				{TemplateId: "order", Locale: "sv", Enabled: false, Subject: "Order {{.number}}", TextBody: "{{if .express}}Express{{end}}"},
+				{TemplateId: "order", Locale: "de", Enabled: true, Subject: "Bestellung {{.number}}", HtmlBody: "{{if .number}"},
+				{TemplateId: "welcome", Locale: "en", Subject: placeholderSubject},
+			},
+		}),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	report, err := app.TemplateHealth()
+	if !assert.NoError(suite.T(), err) || !assert.Len(suite.T(), report.Templates, 2) {
+		return
+	}
+
+	assert.Equal(suite.T(), []string{"de", "en", "sv"}, report.Locales)
+
+	order := report.Templates[0]
+	assert.False(suite.T(), order.Healthy)
+	assert.Empty(suite.T(), order.MissingLocales)
+	assert.Equal(suite.T(), []string{"sv"}, order.DisabledLocales)
+	assert.Equal(suite.T(), map[string][]string{"sv": {"express", "number"}}, order.MissingSampleParameters)
+	assert.Contains(suite.T(), order.RenderErrors, "de")
+	assert.Equal(suite.T(), map[string][]string{"express": {"sv"}, "items": {"en"}}, order.InconsistentParameters, "Locales that do not parse should not make other locales inconsistent")
+
+	welcome := report.Templates[1]
+	assert.Equal(suite.T(), []string{"en"}, welcome.PlaceholderLocales)
+	assert.Equal(suite.T(), []string{"de", "sv"}, welcome.MissingLocales)
+}
+
+func (suite *applicationTestSuite) TestSmsSegmentLimit() {
+	assert.Equal(suite.T(), "\"Smart\" quotes - café a ?", TransliterateSms("“Smart” quotes – café ą 🎉"))
+
+	tpl := Template{
+		TemplateId:     "code",
+		Locale:         "sv",
+		TextBody:       "Din kod är {{.code}} 🔑 " + strings.Repeat("x", 60),
+		SmsMaxSegments: 1,
+	}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(&templateRepository{GetTemplate: tpl}),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	render := smsLimitRender(app.(*application).renderFunc(tpl), tpl)
+
+	_, err = render(FieldTextBody, map[string]interface{}{"code": 1234})
+	assert.Equal(suite.T(), SmsTooLongErr, errors.Cause(err))
+
+	tpl.SmsLimitAction = SmsLimitTransliterate
+	render = smsLimitRender(app.(*application).renderFunc(tpl), tpl)
+
+	body, err := render(FieldTextBody, map[string]interface{}{"code": 1234})
+	if assert.NoError(suite.T(), err) {
+		assert.Equal(suite.T(), "Din kod är 1234 ? "+strings.Repeat("x", 60), body)
+	}
+}
+
+func (suite *applicationTestSuite) TestInbox() {
+	inbox := &inboxRepository{Messages: map[uuid.UUID]InboxMessage{}}
+
+	app, err := NewApplication(
+		SetJobRepo(&jobRepository{}),
+		SetTemplateRepo(&templateRepository{}),
+		SetInboxRepo(inbox),
+	)
+
+	if !assert.NoError(suite.T(), err, "Failed to create the new application") {
+		return
+	}
+
+	tpl := Template{TemplateId: "welcome", Locale: "en", Subject: "Welcome {{.name}}"}
+	job := &Job{Uuid: uuid.New(), Type: JobInbox, Target: "anna", Params: map[string]interface{}{"name": "Anna"}}
+	render := app.(*application).renderFunc(tpl)
+
+	transport := NewInboxTransport(inbox)
+	assert.NoError(suite.T(), transport.Send(context.Background(), job, tpl, render))
+	assert.NoError(suite.T(), transport.Send(context.Background(), job, tpl, render), "Retried jobs should not fail")
+	assert.Equal(suite.T(), "Welcome Anna", inbox.Messages[job.Uuid].Subject)
+
+	request := func(recipient string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+
+		return mux.SetURLVars(r, map[string]string{"recipient": recipient, "uuid": job.Uuid.String()})
+	}
+
+	w := httptest.NewRecorder()
+	app.HttpHandler().MarkInboxMessageRead(w, request("erik"))
+	assert.Equal(suite.T(), 404, w.Code, "Messages of other recipients should not be found")
+	assert.Nil(suite.T(), inbox.Messages[job.Uuid].ReadAt)
+
+	w = httptest.NewRecorder()
+	app.HttpHandler().DeleteInboxMessage(w, request("erik"))
+	assert.Equal(suite.T(), 404, w.Code)
+
+	w = httptest.NewRecorder()
+	app.HttpHandler().MarkInboxMessageRead(w, request("anna"))
+	assert.Equal(suite.T(), 200, w.Code)
+	assert.NotNil(suite.T(), inbox.Messages[job.Uuid].ReadAt)
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/", nil), map[string]string{"recipient": "anna"})
+	w = httptest.NewRecorder()
+	app.HttpHandler().GetInboxUnreadCount(w, r)
+	assert.JSONEq(suite.T(), `{"unread": 0}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	app.HttpHandler().DeleteInboxMessage(w, request("anna"))
+	assert.Equal(suite.T(), 204, w.Code)
+	assert.Empty(suite.T(), inbox.Messages)
+
+	// Deleted messages are gone, so a job retried after the deletion stores its message again
+	assert.NoError(suite.T(), transport.Send(context.Background(), job, tpl, render))
+	assert.Contains(suite.T(), inbox.Messages, job.Uuid)
+
+	inbox.Err = errors.New("connection reset")
+	assert.Error(suite.T(), transport.Send(context.Background(), job, tpl, render), "Only duplicates should count as delivered")
+}
+
+type transport struct {
+	Err   error
+	Sent  chan *Job
+	Calls int
+
+	// Bodies receives the rendered html body of each job when set
+	Bodies chan string
+}
+
+func (t *transport) Send(ctx context.Context, job *Job, template Template, render RenderFunc) error {
+	t.Calls++
+
+	if t.Err != nil {
+		return t.Err
+	}
+
+	if t.Bodies != nil {
+		body, err := render(FieldHtmlBody, job.Params)
+		if err != nil {
+			return err
+		}
+
+		t.Bodies <- body
+	}
+
+	t.Sent <- job
+
+	return nil
+}
+
 type templateRepository struct {
 	GetTemplate    Template
 	MatchTemplates []Template
+	MatchCriteria  []TemplateCriteria
+	Gets           int
+
+	// Templates is used instead of GetTemplate when set, keyed by id:locale
+	Templates map[string]Template
 }
 
 func (repo *templateRepository) Get(id, locale string) (Template, error) {
+	repo.Gets++
+
+	if repo.Templates != nil {
+		tpl, ok := repo.Templates[id+":"+locale]
+		if !ok {
+			return tpl, TemplateNotFoundErr
+		}
+
+		return tpl, nil
+	}
+
 	return repo.GetTemplate, nil
 }
 
 func (repo *templateRepository) Matching(criteria TemplateCriteria) ([]Template, int, error) {
+	repo.MatchCriteria = append(repo.MatchCriteria, criteria)
+
 	return repo.MatchTemplates, len(repo.MatchTemplates), nil
 }
 
 func (repo *templateRepository) Create(template *Template) error {
+	if repo.Templates != nil {
+		repo.Templates[template.TemplateId+":"+template.Locale] = *template
+	}
+
 	return nil
 }
 
 func (repo *templateRepository) Update(template *Template) error {
+	if repo.Templates != nil {
+		repo.Templates[template.TemplateId+":"+template.Locale] = *template
+	}
+
 	return nil
 }
 
@@ -95,11 +1845,114 @@ func (repo *templateRepository) Delete(template *Template) error {
 	return nil
 }
 
+type templateVersionRepository struct {
+	Versions []TemplateVersion
+	Err      error
+}
+
+func (repo *templateVersionRepository) Get(id, locale string, version int) (TemplateVersion, error) {
+	for _, v := range repo.Versions {
+		if v.TemplateId == id && v.Locale == locale && v.Version == version {
+			return v, nil
+		}
+	}
+
+	return TemplateVersion{}, TemplateVersionNotFoundErr
+}
+
+func (repo *templateVersionRepository) Matching(criteria TemplateVersionCriteria) ([]TemplateVersion, int, error) {
+	return repo.Versions, len(repo.Versions), nil
+}
+
+func (repo *templateVersionRepository) Create(version *TemplateVersion) error {
+	if repo.Err != nil {
+		return repo.Err
+	}
+
+	repo.Versions = append(repo.Versions, *version)
+
+	return nil
+}
+
+type inboxRepository struct {
+	Messages map[uuid.UUID]InboxMessage
+	Err      error
+}
+
+func (repo *inboxRepository) Get(id uuid.UUID) (InboxMessage, error) {
+	message, ok := repo.Messages[id]
+	if !ok {
+		return message, InboxMessageNotFoundErr
+	}
+
+	return message, nil
+}
+
+func (repo *inboxRepository) Matching(criteria InboxCriteria) ([]InboxMessage, int, error) {
+	var messages []InboxMessage
+
+	for _, message := range repo.Messages {
+		if message.Recipient == criteria.Recipient {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, len(messages), nil
+}
+
+func (repo *inboxRepository) CountUnread(recipient string) (int, error) {
+	count := 0
+
+	for _, message := range repo.Messages {
+		if message.Recipient == recipient && message.ReadAt == nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (repo *inboxRepository) Create(message *InboxMessage) error {
+	if repo.Err != nil {
+		return repo.Err
+	}
+
+	if _, ok := repo.Messages[message.Uuid]; ok {
+		return InboxMessageExistsErr
+	}
+
+	repo.Messages[message.Uuid] = *message
+
+	return nil
+}
+
+func (repo *inboxRepository) Update(message *InboxMessage) error {
+	repo.Messages[message.Uuid] = *message
+
+	return nil
+}
+
+func (repo *inboxRepository) Delete(message *InboxMessage) error {
+	delete(repo.Messages, message.Uuid)
+
+	return nil
+}
+
 type jobRepository struct {
 	PendingJobs  []Job
 	MatchingJobs []Job
 }
 
+func (repo *jobRepository) Get(id uuid.UUID) (Job, error) {
+	for _, job := range repo.MatchingJobs {
+		if job.Uuid == id {
+			return job, nil
+		}
+	}
+
+	return Job{}, JobNotFoundErr
+}
+
 func (repo *jobRepository) GetPending() ([]Job, error) {
 	return repo.PendingJobs, nil
 }
@@ -0,0 +1,81 @@
+package communication
+
+import (
+	"context"
+	"mime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AttachmentLoader loads the content of an attachment reference when the job is sent
+type AttachmentLoader func(ctx context.Context, reference string) ([]byte, error)
+
+// Attachment is a file sent along with an email job
+type Attachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+
+	// Inline attachments are referenced from the html body using cid:<Name>
+	Inline bool `json:"inline"`
+
+	// Content is persisted with the job, prefer Reference for large files
+	Content []byte `json:"content,omitempty"`
+
+	// Reference is resolved using the application attachment loader each time the job is sent
+	Reference string `json:"reference,omitempty"`
+
+	loader AttachmentLoader
+}
+
+// Load returns the content of the attachment, resolving the reference if needed
+func (a Attachment) Load(ctx context.Context) ([]byte, error) {
+	if a.Reference == "" {
+		return a.Content, nil
+	}
+
+	if a.loader == nil {
+		return nil, errors.Errorf("No attachment loader configured to load %s", a.Reference)
+	}
+
+	content, err := a.loader(ctx, a.Reference)
+
+	return content, errors.Wrapf(err, "Failed to load attachment %s", a.Reference)
+}
+
+// WithAttachments adds attachments or inline images to an email
+func WithAttachments(attachments ...Attachment) SendOption {
+	return func(job *Job) {
+		job.Attachments = append(job.Attachments, attachments...)
+	}
+}
+
+func (a *application) validateAttachments(attachments []Attachment) error {
+	for _, attachment := range attachments {
+		if attachment.Name == "" {
+			return errors.New("Attachment is missing a name")
+		}
+
+		// The name ends up in the Content-Disposition and Content-ID headers of the message
+		if strings.IndexFunc(attachment.Name, func(r rune) bool { return r < ' ' || r == 127 }) >= 0 {
+			return errors.Errorf("Attachment name %q contains control characters", attachment.Name)
+		}
+
+		if attachment.Inline && strings.ContainsAny(attachment.Name, "<>") {
+			return errors.Errorf("Inline attachment name %q can not be used as a content id", attachment.Name)
+		}
+
+		if attachment.ContentType != "" {
+			mediaType, params, err := mime.ParseMediaType(attachment.ContentType)
+			if err != nil || mime.FormatMediaType(mediaType, params) == "" {
+				return errors.Errorf("Invalid content type %q for attachment %s", attachment.ContentType, attachment.Name)
+			}
+		}
+
+		if attachment.Reference != "" && a.attachmentLoader == nil {
+			return errors.Errorf("No attachment loader configured to load %s", attachment.Reference)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,368 @@
+package communication
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// BundleFormat is the serialization of a template bundle
+type BundleFormat string
+
+const (
+	BundleFormatJson BundleFormat = "json"
+	BundleFormatYaml BundleFormat = "yaml"
+)
+
+// TemplateBundle is a set of templates moved between environments
+type TemplateBundle struct {
+	ExportedAt time.Time  `json:"exportedAt"`
+	Templates  []Template `json:"templates"`
+}
+
+type TemplateChangeType string
+
+const (
+	TemplateCreated   TemplateChangeType = "created"
+	TemplateUpdated   TemplateChangeType = "updated"
+	TemplateUnchanged TemplateChangeType = "unchanged"
+)
+
+// FieldChange is a field of a template that differs between the repository and the bundle
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// TemplateChange describes what importing a template of a bundle does
+type TemplateChange struct {
+	TemplateId string             `json:"id"`
+	Locale     string             `json:"locale"`
+	Type       TemplateChangeType `json:"type"`
+	Fields     []FieldChange      `json:"fields"`
+
+	previous Template
+	template Template
+}
+
+// InvalidBundleError is returned when a template of an imported bundle can not be rendered or sent
+type InvalidBundleError struct {
+	TemplateId string
+	Locale     string
+	Err        error
+}
+
+func (e *InvalidBundleError) Error() string {
+	return fmt.Sprintf("Invalid template %s for locale %s: %s", e.TemplateId, e.Locale, e.Err)
+}
+
+// bundleFields are the fields of a template that are exported and imported
+var bundleFields = []struct {
+	name  string
+	get   func(t *Template) interface{}
+	apply func(to *Template, from Template)
+}{
+	{"enabled", func(t *Template) interface{} { return t.Enabled }, func(to *Template, from Template) { to.Enabled = from.Enabled }},
+	{"description", func(t *Template) interface{} { return t.Description }, func(to *Template, from Template) { to.Description = from.Description }},
+	{"kind", func(t *Template) interface{} { return t.Kind }, func(to *Template, from Template) { to.Kind = from.Kind }},
+	{"layout", func(t *Template) interface{} { return t.Layout }, func(to *Template, from Template) { to.Layout = from.Layout }},
+	{"parameters", func(t *Template) interface{} { return t.Parameters }, func(to *Template, from Template) { to.Parameters = from.Parameters }},
+	{"updateParameters", func(t *Template) interface{} { return t.UpdateParameters }, func(to *Template, from Template) { to.UpdateParameters = from.UpdateParameters }},
+	{"schema", func(t *Template) interface{} { return t.Schema }, func(to *Template, from Template) { to.Schema = from.Schema }},
+	{"subject", func(t *Template) interface{} { return t.Subject }, func(to *Template, from Template) { to.Subject = from.Subject }},
+	{"textBody", func(t *Template) interface{} { return t.TextBody }, func(to *Template, from Template) { to.TextBody = from.TextBody }},
+	{"htmlBody", func(t *Template) interface{} { return t.HtmlBody }, func(to *Template, from Template) { to.HtmlBody = from.HtmlBody }},
+	{"bodyFormat", func(t *Template) interface{} { return t.BodyFormat }, func(to *Template, from Template) { to.BodyFormat = from.BodyFormat }},
+	{"fromName", func(t *Template) interface{} { return t.FromName }, func(to *Template, from Template) { to.FromName = from.FromName }},
+	{"fromAddress", func(t *Template) interface{} { return t.FromAddress }, func(to *Template, from Template) { to.FromAddress = from.FromAddress }},
+	{"replyTo", func(t *Template) interface{} { return t.ReplyTo }, func(to *Template, from Template) { to.ReplyTo = from.ReplyTo }},
+	{"smsSender", func(t *Template) interface{} { return t.SmsSender }, func(to *Template, from Template) { to.SmsSender = from.SmsSender }},
+	{"smsMaxSegments", func(t *Template) interface{} { return t.SmsMaxSegments }, func(to *Template, from Template) { to.SmsMaxSegments = from.SmsMaxSegments }},
+	{"smsLimitAction", func(t *Template) interface{} { return t.SmsLimitAction }, func(to *Template, from Template) { to.SmsLimitAction = from.SmsLimitAction }},
+}
+
+// ExportTemplates exports the templates matching the criteria ordered by id and locale, ignoring its limit and offset
+func ExportTemplates(repo TemplateRepository, criteria TemplateCriteria) (TemplateBundle, error) {
+	bundle := TemplateBundle{
+		ExportedAt: time.Now(),
+	}
+
+	templates, err := allTemplates(repo, criteria)
+	if err != nil {
+		return bundle, err
+	}
+
+	// Unpublished changes stay in the environment they were made in
+	for i := range templates {
+		templates[i].Draft = nil
+	}
+
+	bundle.Templates = templates
+
+	return bundle, nil
+}
+
+// allTemplates pages through all templates matching the criteria ordered by id and locale, ignoring
+// its limit, offset and sorting
+func allTemplates(repo TemplateRepository, criteria TemplateCriteria) ([]Template, error) {
+	all := []Template{}
+
+	criteria.Offset = 0
+	criteria.Limit = 100
+
+	// Pages are only stable when the order is unique
+	criteria.Sorting = map[string]string{
+		"template_id": "asc",
+		"locale":      "asc",
+	}
+
+	for {
+		templates, count, err := repo.Matching(criteria)
+		if err != nil {
+			return all, errors.Wrap(err, "Failed to retrieve templates")
+		}
+
+		all = append(all, templates...)
+		criteria.Offset += len(templates)
+
+		if len(templates) == 0 || criteria.Offset >= count {
+			return all, nil
+		}
+	}
+}
+
+// Validate checks that every template of the bundle can be imported
+func (bundle TemplateBundle) Validate() error {
+	seen := map[string]bool{}
+
+	for _, template := range bundle.Templates {
+		if template.TemplateId == "" || template.Locale == "" {
+			return errors.New("Both id and locale are required for bundled templates")
+		}
+
+		switch template.Kind {
+		case "", TemplateKindContent, TemplateKindLayout, TemplateKindPartial:
+
+		default:
+			return errors.Errorf("Unsupported kind %s for template %s", template.Kind, template.TemplateId)
+		}
+
+		key := template.Locale + ":" + template.TemplateId
+		if seen[key] {
+			return errors.Errorf("Template %s is bundled more than once", key)
+		}
+
+		seen[key] = true
+	}
+
+	return nil
+}
+
+func diffBundle(repo TemplateRepository, bundle TemplateBundle) ([]TemplateChange, error) {
+	changes := make([]TemplateChange, 0, len(bundle.Templates))
+
+	if err := bundle.Validate(); err != nil {
+		return changes, err
+	}
+
+	for _, imported := range bundle.Templates {
+		change := TemplateChange{
+			TemplateId: imported.TemplateId,
+			Locale:     imported.Locale,
+			Fields:     []FieldChange{},
+		}
+
+		existing, err := repo.Get(imported.TemplateId, imported.Locale)
+		switch err {
+		case nil:
+			change.Type = TemplateUnchanged
+			change.previous = existing
+			change.template = existing
+
+		case TemplateNotFoundErr:
+			change.Type = TemplateCreated
+			change.template = Template{
+				TemplateId: imported.TemplateId,
+				Locale:     imported.Locale,
+				Version:    1,
+				CreatedAt:  time.Now(),
+				UpdatedAt:  time.Now(),
+			}
+
+		default:
+			return changes, errors.Wrapf(err, "Failed to retrieve template %s for locale %s", imported.TemplateId, imported.Locale)
+		}
+
+		for _, field := range bundleFields {
+			from, to := field.get(&change.template), field.get(&imported)
+			if sameBundleValue(from, to) {
+				continue
+			}
+
+			field.apply(&change.template, imported)
+
+			if change.Type != TemplateCreated {
+				change.Type = TemplateUpdated
+				change.Fields = append(change.Fields, FieldChange{Field: field.name, From: from, To: to})
+			}
+		}
+
+		if change.Type == TemplateUpdated {
+			change.template.Version++
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// sameBundleValue compares field values, treating nil and empty maps and slices as equal
+func sameBundleValue(a, b interface{}) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+
+	switch va.Kind() {
+	case reflect.Map, reflect.Slice:
+		if va.Len() == 0 && vb.Len() == 0 {
+			return true
+		}
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// ImportTemplates imports a bundle like the http handler changes templates, recording versions and
+// storing new and changed templates as drafts when using the draft workflow. All templates are validated
+// before any of them is written, if writing fails the changes applied so far are returned with the error
+func (a *application) ImportTemplates(bundle TemplateBundle, dryRun bool, author string) ([]TemplateChange, error) {
+	changes, err := diffBundle(a.templateRepo, bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.validateImport(changes); err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return changes, nil
+	}
+
+	for i, change := range changes {
+		template := change.template
+
+		switch change.Type {
+		case TemplateCreated:
+			var version *TemplateVersion
+
+			if a.draftWorkflow {
+				template = newDraftTemplate(template, author)
+			} else {
+				published := newTemplateVersion(template)
+				published.Author = author
+
+				version = &published
+			}
+
+			err = a.createTemplate(&template, version)
+
+		case TemplateUpdated:
+			var version *TemplateVersion
+
+			if a.draftWorkflow {
+				template = change.previous.withDraft(template, author)
+			} else {
+				published := newTemplateVersion(template)
+				published.Author = author
+
+				version = &published
+			}
+
+			err = a.saveTemplate(change.previous, &template, version)
+		}
+
+		if err != nil {
+			return changes[:i], errors.Wrapf(err, "Failed to import template %s for locale %s", change.TemplateId, change.Locale)
+		}
+	}
+
+	return changes, nil
+}
+
+// validateImport validates the imported content like the create and update handlers do, resolving
+// layouts and partials from the bundle before the repository
+func (a *application) validateImport(changes []TemplateChange) error {
+	templates := make([]Template, 0, len(changes))
+	for _, change := range changes {
+		templates = append(templates, change.template)
+	}
+
+	validator := a.validatorWith(templates)
+
+	for _, change := range changes {
+		if change.Type == TemplateUnchanged {
+			continue
+		}
+
+		if _, err := validator.validateTemplate(change.template); err != nil {
+			return &InvalidBundleError{TemplateId: change.TemplateId, Locale: change.Locale, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// EncodeTemplateBundle writes the bundle in the given format
+func EncodeTemplateBundle(w io.Writer, bundle TemplateBundle, format BundleFormat) error {
+	var data []byte
+	var err error
+
+	switch format {
+	case BundleFormatJson, "":
+		data, err = json.MarshalIndent(bundle, "", "  ")
+
+	case BundleFormatYaml:
+		data, err = yaml.Marshal(bundle)
+
+	default:
+		return errors.Errorf("Unsupported bundle format %s", format)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode template bundle")
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
+
+// DecodeTemplateBundle reads a bundle in the given format
+func DecodeTemplateBundle(r io.Reader, format BundleFormat) (TemplateBundle, error) {
+	bundle := TemplateBundle{}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return bundle, errors.Wrap(err, "Failed to read template bundle")
+	}
+
+	switch format {
+	case BundleFormatJson, "":
+		err = json.Unmarshal(data, &bundle)
+
+	case BundleFormatYaml:
+		err = yaml.Unmarshal(data, &bundle)
+
+	default:
+		return bundle, errors.Errorf("Unsupported bundle format %s", format)
+	}
+
+	return bundle, errors.Wrap(err, "Failed to decode template bundle")
+}
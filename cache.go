@@ -0,0 +1,188 @@
+package communication
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TemplateInvalidator propagates template changes between application instances
+// sharing the same template repository
+type TemplateInvalidator interface {
+	Publish(templateId, locale string) error
+	Subscribe(ctx context.Context, handler func(templateId, locale string)) error
+}
+
+// SetTemplateCacheTTL caches templates and their parsed bodies for the given duration, zero disables caching
+func SetTemplateCacheTTL(ttl time.Duration) AppOption {
+	return func(a *application) {
+		a.templateCache.ttl = ttl
+	}
+}
+
+// SetTemplateInvalidator notifies other instances when a template is changed through this instance
+func SetTemplateInvalidator(invalidator TemplateInvalidator) AppOption {
+	return func(a *application) {
+		a.templateInvalidator = invalidator
+	}
+}
+
+type templateKey struct {
+	templateId string
+	locale     string
+}
+
+type compiledKey struct {
+	templateKey
+	updatedAt int64
+}
+
+type cachedTemplate struct {
+	template  Template
+	expiresAt time.Time
+}
+
+type cachedCompiled struct {
+	compiled  *compiledTemplate
+	expiresAt time.Time
+}
+
+type templateCache struct {
+	sync.RWMutex
+
+	ttl time.Duration
+
+	templates map[templateKey]cachedTemplate
+	compiled  map[compiledKey]cachedCompiled
+}
+
+func newTemplateCache() *templateCache {
+	return &templateCache{
+		templates: map[templateKey]cachedTemplate{},
+		compiled:  map[compiledKey]cachedCompiled{},
+	}
+}
+
+func (c *templateCache) getTemplate(templateId, locale string) (Template, bool) {
+	if c.ttl <= 0 {
+		return Template{}, false
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+
+	cached, ok := c.templates[templateKey{templateId, locale}]
+	if !ok || time.Now().After(cached.expiresAt) {
+		return Template{}, false
+	}
+
+	return cached.template, true
+}
+
+func (c *templateCache) setTemplate(template Template) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.templates[templateKey{template.TemplateId, template.Locale}] = cachedTemplate{
+		template:  template,
+		expiresAt: time.Now().Add(c.ttl),
+	}
+}
+
+func (c *templateCache) getCompiled(template Template) (*compiledTemplate, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+
+	cached, ok := c.compiled[compiledKeyFor(template)]
+	if !ok || time.Now().After(cached.expiresAt) {
+		return nil, false
+	}
+
+	return cached.compiled, true
+}
+
+func (c *templateCache) setCompiled(template Template, compiled *compiledTemplate) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+
+	// Parsed templates of older revisions are only removed once expired
+	for key, cached := range c.compiled {
+		if now.After(cached.expiresAt) {
+			delete(c.compiled, key)
+		}
+	}
+
+	c.compiled[compiledKeyFor(template)] = cachedCompiled{
+		compiled:  compiled,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+func (c *templateCache) invalidate(templateId, locale string) {
+	c.Lock()
+	defer c.Unlock()
+
+	key := templateKey{templateId, locale}
+
+	delete(c.templates, key)
+
+	for k, cached := range c.compiled {
+		if k.templateKey == key || cached.compiled.dependsOn(key) {
+			delete(c.compiled, k)
+		}
+	}
+}
+
+func compiledKeyFor(template Template) compiledKey {
+	return compiledKey{
+		templateKey: templateKey{template.TemplateId, template.Locale},
+		updatedAt:   template.UpdatedAt.UnixNano(),
+	}
+}
+
+// lookupTemplate retrieves a template from the cache or the template repository
+func (a *application) lookupTemplate(templateId, locale string) (Template, error) {
+	if tpl, ok := a.templateCache.getTemplate(templateId, locale); ok {
+		return tpl, nil
+	}
+
+	tpl, err := a.templateRepo.Get(templateId, locale)
+	if err != nil {
+		return tpl, err
+	}
+
+	a.templateCache.setTemplate(tpl)
+
+	return tpl, nil
+}
+
+// invalidateTemplate drops the template from the cache of this and all other instances
+func (a *application) invalidateTemplate(templateId, locale string) {
+	a.templateCache.invalidate(templateId, locale)
+
+	if a.templateInvalidator == nil {
+		return
+	}
+
+	if err := a.templateInvalidator.Publish(templateId, locale); err != nil {
+		a.logger.
+			WithField("templateId", templateId).
+			WithField("locale", locale).
+			WithError(err).
+			Error("Failed to publish template invalidation")
+	}
+}
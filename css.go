@@ -0,0 +1,424 @@
+package communication
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// SetCssInlining inlines the css of <style> blocks into the style attributes of html emails,
+// rules that can not be inlined such as media queries are kept in the <style> block
+func SetCssInlining(enabled bool) AppOption {
+	return func(a *application) {
+		a.cssInlining = enabled
+	}
+}
+
+// nonVisualElements never receive inlined styles
+var nonVisualElements = map[string]bool{
+	"head":   true,
+	"title":  true,
+	"meta":   true,
+	"link":   true,
+	"style":  true,
+	"script": true,
+	"base":   true,
+}
+
+type cssDeclaration struct {
+	property  string
+	value     string
+	important bool
+}
+
+type cssRule struct {
+	match        cascadia.Selector
+	specificity  int
+	order        int
+	declarations []cssDeclaration
+}
+
+// inlineCssRender inlines css into the html body rendered by render
+func inlineCssRender(render RenderFunc) RenderFunc {
+	return func(field TemplateField, params map[string]interface{}) (string, error) {
+		body, err := render(field, params)
+		if err != nil || field != FieldHtmlBody {
+			return body, err
+		}
+
+		return inlineCss(body)
+	}
+}
+
+func inlineCss(body string) (string, error) {
+	if !strings.Contains(strings.ToLower(body), "<style") {
+		return body, nil
+	}
+
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	var rules []cssRule
+	var styles []*html.Node
+
+	walkHtml(doc, func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "style" {
+			styles = append(styles, n)
+		}
+	})
+
+	for _, style := range styles {
+		if t := htmlAttr(style, "type"); t != "" && t != "text/css" {
+			continue
+		}
+
+		css := &bytes.Buffer{}
+		for c := style.FirstChild; c != nil; c = c.NextSibling {
+			css.WriteString(c.Data)
+		}
+
+		parsed, residual := parseStylesheet(css.String(), len(rules))
+		rules = append(rules, parsed...)
+
+		if strings.TrimSpace(residual) == "" {
+			style.Parent.RemoveChild(style)
+			continue
+		}
+
+		for style.FirstChild != nil {
+			style.RemoveChild(style.FirstChild)
+		}
+
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: residual})
+	}
+
+	if len(rules) > 0 {
+		walkHtml(doc, func(n *html.Node) {
+			if n.Type == html.ElementNode && !nonVisualElements[n.Data] {
+				applyCssRules(n, rules)
+			}
+		})
+	}
+
+	out := &bytes.Buffer{}
+	if err := html.Render(out, doc); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+func applyCssRules(n *html.Node, rules []cssRule) {
+	var matched []cssRule
+
+	for _, rule := range rules {
+		if rule.match(n) {
+			matched = append(matched, rule)
+		}
+	}
+
+	if len(matched) == 0 {
+		return
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].specificity != matched[j].specificity {
+			return matched[i].specificity < matched[j].specificity
+		}
+
+		return matched[i].order < matched[j].order
+	})
+
+	var properties []string
+	values := map[string]cssDeclaration{}
+
+	// Later declarations win unless an earlier one is important, this also applies to the
+	// existing inline style which only loses against important stylesheet declarations
+	set := func(declaration cssDeclaration) {
+		existing, ok := values[declaration.property]
+		if !ok {
+			properties = append(properties, declaration.property)
+		} else if existing.important && !declaration.important {
+			return
+		}
+
+		values[declaration.property] = declaration
+	}
+
+	for _, rule := range matched {
+		for _, declaration := range rule.declarations {
+			set(declaration)
+		}
+	}
+
+	for _, declaration := range parseDeclarations(htmlAttr(n, "style")) {
+		set(declaration)
+	}
+
+	style := make([]string, 0, len(properties))
+	for _, property := range properties {
+		declaration := values[property]
+		value := declaration.value
+
+		if declaration.important {
+			value += " !important"
+		}
+
+		style = append(style, property+": "+value)
+	}
+
+	setHtmlAttr(n, "style", strings.Join(style, "; ")+";")
+}
+
+// parseStylesheet returns the rules that can be inlined and the css that has to be kept
+func parseStylesheet(css string, order int) ([]cssRule, string) {
+	css = stripCssComments(css)
+
+	var rules []cssRule
+	residual := &bytes.Buffer{}
+
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			break
+		}
+
+		if css[0] == '@' {
+			end := atRuleEnd(css)
+			residual.WriteString(css[:end] + "\n")
+			css = css[end:]
+
+			continue
+		}
+
+		open := strings.Index(css, "{")
+		if open < 0 {
+			break
+		}
+
+		end := cssIndex(css[open:], '}')
+		if end < 0 {
+			residual.WriteString(css)
+			break
+		}
+
+		end += open
+
+		selectors := css[:open]
+		body := css[open+1 : end]
+		css = css[end+1:]
+
+		declarations := parseDeclarations(body)
+
+		for _, selector := range splitCss(selectors, ',') {
+			selector = strings.TrimSpace(selector)
+			if selector == "" {
+				continue
+			}
+
+			// Pseudo classes and elements like :hover only apply in the client, colons in
+			// attribute selectors like a[href^="http:"] are part of the attribute value
+			match, err := cascadia.Compile(selector)
+			if err != nil || cssIndex(selector, ':') >= 0 {
+				residual.WriteString(selector + " {" + body + "}\n")
+				continue
+			}
+
+			rules = append(rules, cssRule{
+				match:        match,
+				specificity:  cssSpecificity(selector),
+				order:        order,
+				declarations: declarations,
+			})
+
+			order++
+		}
+	}
+
+	return rules, residual.String()
+}
+
+// atRuleEnd returns the end of the at-rule css starts with, including its block
+func atRuleEnd(css string) int {
+	semicolon := strings.Index(css, ";")
+	open := strings.Index(css, "{")
+
+	if open < 0 || (semicolon >= 0 && semicolon < open) {
+		if semicolon < 0 {
+			return len(css)
+		}
+
+		return semicolon + 1
+	}
+
+	depth := 0
+
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return len(css)
+}
+
+func parseDeclarations(body string) []cssDeclaration {
+	var declarations []cssDeclaration
+
+	for _, part := range splitCss(body, ';') {
+		split := strings.SplitN(part, ":", 2)
+		if len(split) != 2 {
+			continue
+		}
+
+		declaration := cssDeclaration{
+			property: strings.ToLower(strings.TrimSpace(split[0])),
+			value:    strings.TrimSpace(split[1]),
+		}
+
+		if strings.HasSuffix(strings.ToLower(declaration.value), "!important") {
+			declaration.value = strings.TrimSpace(declaration.value[:len(declaration.value)-len("!important")])
+			declaration.important = true
+		}
+
+		if declaration.property == "" || declaration.value == "" {
+			continue
+		}
+
+		declarations = append(declarations, declaration)
+	}
+
+	return declarations
+}
+
+// splitCss splits css on sep, ignoring separators in quoted strings and parentheses like url(data:...;base64,...)
+func splitCss(css string, sep byte) []string {
+	var parts []string
+
+	for {
+		i := cssIndex(css, sep)
+		if i < 0 {
+			return append(parts, css)
+		}
+
+		parts = append(parts, css[:i])
+		css = css[i+1:]
+	}
+}
+
+// cssIndex returns the index of the first c in css outside of quoted strings, parentheses and brackets, or -1
+func cssIndex(css string, c byte) int {
+	var quote byte
+	depth := 0
+
+	for i := 0; i < len(css); i++ {
+		switch ch := css[i]; {
+		case ch == '\\':
+			i++
+
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+
+		case ch == '"' || ch == '\'':
+			quote = ch
+
+		case ch == '(' || ch == '[':
+			depth++
+
+		case (ch == ')' || ch == ']') && depth > 0:
+			depth--
+
+		case ch == c && depth == 0:
+			return i
+		}
+	}
+
+	return -1
+}
+
+// cssSpecificity approximates the specificity of a selector as ids, classes and attributes, then types
+func cssSpecificity(selector string) int {
+	ids, classes, types := 0, 0, 0
+
+	compounds := strings.FieldsFunc(selector, func(r rune) bool {
+		return r == ' ' || r == '>' || r == '+' || r == '~' || r == '\t' || r == '\n'
+	})
+
+	for _, compound := range compounds {
+		if c := compound[0]; c != '#' && c != '.' && c != '[' && c != '*' {
+			types++
+		}
+
+		ids += strings.Count(compound, "#")
+		classes += strings.Count(compound, ".") + strings.Count(compound, "[")
+	}
+
+	return ids*10000 + classes*100 + types
+}
+
+func stripCssComments(css string) string {
+	out := &bytes.Buffer{}
+
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			out.WriteString(css)
+			return out.String()
+		}
+
+		out.WriteString(css[:start])
+
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			return out.String()
+		}
+
+		css = css[start+2+end+2:]
+	}
+}
+
+func walkHtml(n *html.Node, fn func(*html.Node)) {
+	fn(n)
+
+	for c := n.FirstChild; c != nil; {
+		// Keep a reference to the next sibling as fn may detach c
+		next := c.NextSibling
+		walkHtml(c, fn)
+		c = next
+	}
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
+
+func setHtmlAttr(n *html.Node, key, value string) {
+	for i, attr := range n.Attr {
+		if attr.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
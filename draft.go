@@ -0,0 +1,122 @@
+package communication
+
+import (
+	"github.com/pkg/errors"
+)
+
+// SetDraftWorkflow makes template changes made through the http handler drafts that have to be
+// published before they are sent, optionally requiring someone other than the author to publish
+func SetDraftWorkflow(requireApproval bool) AppOption {
+	return func(a *application) {
+		a.draftWorkflow = true
+		a.publishApproval = requireApproval
+	}
+}
+
+// withDraft stores the content of changed as the draft of the template, leaving the published
+// content untouched while parameter collection takes effect immediately. Enabling is part of the
+// draft, so placeholders are not sent to customers before their content is published
+func (t Template) withDraft(changed Template, author string) Template {
+	draft := newTemplateVersion(changed)
+	draft.Version = t.Version + 1
+	draft.Author = author
+	draft.Enabled = changed.Enabled
+
+	t.UpdateParameters = changed.UpdateParameters
+	t.Draft = &draft
+
+	return t
+}
+
+// newDraftTemplate returns a disabled template without published content holding template as its
+// draft, used to create templates when using the draft workflow
+func newDraftTemplate(template Template, author string) Template {
+	created := Template{
+		TemplateId:       template.TemplateId,
+		Locale:           template.Locale,
+		Kind:             template.Kind,
+		Parameters:       template.Parameters,
+		UpdateParameters: template.UpdateParameters,
+		CreatedAt:        template.CreatedAt,
+		UpdatedAt:        template.UpdatedAt,
+	}
+
+	return created.withDraft(template, author)
+}
+
+// empty returns if the template has no published content, e.g. templates created as drafts
+func (t Template) empty() bool {
+	return t.Subject == "" && t.TextBody == "" && t.HtmlBody == ""
+}
+
+// drafted returns the template with the draft content in place of the published content
+func (t Template) drafted() Template {
+	if t.Draft != nil {
+		t.Draft.restore(&t)
+	}
+
+	return t
+}
+
+// useDraft renders the job using the draft of the template, used for test sends
+func useDraft() SendOption {
+	return func(job *Job) {
+		job.Draft = true
+	}
+}
+
+// saveTemplate stores a changed template and records the version when published content changed
+func (a *application) saveTemplate(previous Template, template *Template, version *TemplateVersion) error {
+	// The version is stored first so published content never lacks its history
+	if version != nil {
+		if err := a.recordVersion(previous, *version); err != nil {
+			return err
+		}
+	}
+
+	if err := a.templateRepo.Update(template); err != nil {
+		return errors.Wrapf(err, "Failed to update template %s", template.TemplateId)
+	}
+
+	// Drafts are never sent, so held jobs are only released when content is published
+	if version == nil {
+		a.invalidateTemplate(template.TemplateId, template.Locale)
+
+		return nil
+	}
+
+	a.templateChanged(*template)
+
+	return nil
+}
+
+// createTemplate stores a new template together with its first version
+func (a *application) createTemplate(template *Template, version *TemplateVersion) error {
+	if version != nil {
+		if err := a.recordVersion(Template{}, *version); err != nil {
+			return err
+		}
+	}
+
+	if err := a.templateRepo.Create(template); err != nil {
+		return errors.Wrapf(err, "Failed to create template %s", template.TemplateId)
+	}
+
+	a.templateChanged(*template)
+
+	return nil
+}
+
+// templateChanged invalidates the cache and releases jobs held for the template
+func (a *application) templateChanged(template Template) {
+	a.invalidateTemplate(template.TemplateId, template.Locale)
+
+	if template.Enabled {
+		if err := a.releaseHeldJobs(template.TemplateId); err != nil {
+			a.logger.
+				WithField("templateId", template.TemplateId).
+				WithError(err).
+				Error("Failed to release held jobs")
+		}
+	}
+}
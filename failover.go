@@ -0,0 +1,261 @@
+package communication
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FailoverProvider is a named transport used by the failover transport
+type FailoverProvider struct {
+	Name      string
+	Transport Transport
+
+	// Weight splits traffic between weighted providers, zero only uses the provider for failover
+	Weight int
+}
+
+type FailoverOption func(t *failoverTransport)
+
+// SetFailoverThreshold sets the number of consecutive failures that opens the circuit of a provider
+func SetFailoverThreshold(threshold int) FailoverOption {
+	return func(t *failoverTransport) {
+		t.threshold = threshold
+	}
+}
+
+// SetFailoverCooldown sets for how long a provider with an open circuit is skipped
+func SetFailoverCooldown(cooldown time.Duration) FailoverOption {
+	return func(t *failoverTransport) {
+		t.cooldown = cooldown
+	}
+}
+
+type providerState struct {
+	failures  int
+	openUntil time.Time
+}
+
+type failoverTransport struct {
+	sync.Mutex
+
+	providers []FailoverProvider
+	states    []providerState
+	random    *rand.Rand
+
+	threshold int
+	cooldown  time.Duration
+}
+
+// NewFailoverTransport creates a transport that sends through the providers in order,
+// failing over to the next one on error and skipping providers that keep failing
+func NewFailoverTransport(providers []FailoverProvider, options ...FailoverOption) Transport {
+	t := &failoverTransport{
+		providers: providers,
+		states:    make([]providerState, len(providers)),
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+
+		threshold: 3,
+		cooldown:  time.Minute,
+	}
+
+	for _, option := range options {
+		option(t)
+	}
+
+	return t
+}
+
+func (t *failoverTransport) Send(ctx context.Context, job *Job, template Template, render RenderFunc) error {
+	if len(t.providers) == 0 {
+		return errors.New("No providers configured for failover transport")
+	}
+
+	var failures []string
+
+	// Render errors are caused by the template, not the provider, so they neither count
+	// as a failure of the provider nor fail over as the next provider renders the same way
+	var renderErr error
+
+	tracked := func(field TemplateField, params map[string]interface{}) (string, error) {
+		rendered, err := render(field, params)
+		if err != nil {
+			renderErr = err
+		}
+
+		return rendered, err
+	}
+
+	for _, i := range t.order() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		provider := t.providers[i]
+
+		if err := provider.Transport.Send(ctx, job, template, tracked); err != nil {
+			if renderErr != nil {
+				return err
+			}
+
+			t.failure(i)
+			failures = append(failures, provider.Name+": "+err.Error())
+
+			continue
+		}
+
+		t.success(i)
+		job.Provider = provider.Name
+
+		return nil
+	}
+
+	return errors.Errorf("All providers failed to send job %s: %s", job.Uuid, strings.Join(failures, "; "))
+}
+
+// order returns the provider indexes in the order they should be attempted,
+// providers with an open circuit are only attempted as a last resort
+func (t *failoverTransport) order() []int {
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+
+	var healthy, unhealthy []int
+
+	for i := range t.providers {
+		if now.Before(t.states[i].openUntil) {
+			unhealthy = append(unhealthy, i)
+		} else {
+			healthy = append(healthy, i)
+		}
+	}
+
+	if pos := t.pickWeighted(healthy); pos > 0 {
+		// Move the weighted pick to the front while keeping the failover order for the rest
+		picked := healthy[pos]
+		copy(healthy[1:pos+1], healthy[:pos])
+		healthy[0] = picked
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// pickWeighted returns the position in indexes of a provider picked by weight, or -1 if none are weighted
+func (t *failoverTransport) pickWeighted(indexes []int) int {
+	total := 0
+	for _, i := range indexes {
+		total += t.providers[i].Weight
+	}
+
+	if total <= 0 {
+		return -1
+	}
+
+	n := t.random.Intn(total)
+
+	for pos, i := range indexes {
+		if t.providers[i].Weight <= 0 {
+			continue
+		}
+
+		if n < t.providers[i].Weight {
+			return pos
+		}
+
+		n -= t.providers[i].Weight
+	}
+
+	return -1
+}
+
+func (t *failoverTransport) success(i int) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.states[i] = providerState{}
+}
+
+func (t *failoverTransport) failure(i int) {
+	t.Lock()
+	defer t.Unlock()
+
+	// Once the threshold is reached every failure re-opens the circuit, so a provider
+	// that fails its first attempt after the cooldown is skipped again right away
+	t.states[i].failures++
+	if t.states[i].failures >= t.threshold {
+		t.states[i].openUntil = time.Now().Add(t.cooldown)
+	}
+}
+
+func (t *failoverTransport) GetUnsubscribedTemplates(ctx context.Context, email string) ([]string, error) {
+	seen := map[string]bool{}
+	templates := []string{}
+	supported := false
+
+	for _, provider := range t.providers {
+		transport, ok := provider.Transport.(TransportSupportsSubscriptionBlocking)
+		if !ok {
+			continue
+		}
+
+		supported = true
+
+		unsubscribed, err := transport.GetUnsubscribedTemplates(ctx, email)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to retrieve unsubscribed templates from %s", provider.Name)
+		}
+
+		for _, template := range unsubscribed {
+			if !seen[template] {
+				seen[template] = true
+				templates = append(templates, template)
+			}
+		}
+	}
+
+	if !supported {
+		return nil, errors.New("No provider supports subscription blocking")
+	}
+
+	return templates, nil
+}
+
+func (t *failoverTransport) ResubscribeToAll(ctx context.Context, email string) error {
+	return t.eachSubscriptionProvider(func(transport TransportSupportsSubscriptionBlocking) error {
+		return transport.ResubscribeToAll(ctx, email)
+	})
+}
+
+func (t *failoverTransport) ResubscribeToTemplate(ctx context.Context, email, template string) error {
+	return t.eachSubscriptionProvider(func(transport TransportSupportsSubscriptionBlocking) error {
+		return transport.ResubscribeToTemplate(ctx, email, template)
+	})
+}
+
+func (t *failoverTransport) eachSubscriptionProvider(f func(transport TransportSupportsSubscriptionBlocking) error) error {
+	supported := false
+
+	for _, provider := range t.providers {
+		transport, ok := provider.Transport.(TransportSupportsSubscriptionBlocking)
+		if !ok {
+			continue
+		}
+
+		supported = true
+
+		if err := f(transport); err != nil {
+			return errors.Wrapf(err, "Failed to resubscribe with %s", provider.Name)
+		}
+	}
+
+	if !supported {
+		return errors.New("No provider supports subscription blocking")
+	}
+
+	return nil
+}
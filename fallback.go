@@ -0,0 +1,305 @@
+package communication
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// FallbackStep is one channel in a fallback chain, see Application.SendWithFallback
+type FallbackStep struct {
+	Type   JobType `json:"type"`
+	Target string  `json:"target"`
+
+	// OnError escalates to the next step when the transport fails to send the job
+	OnError bool `json:"onError"`
+
+	// Timeout escalates to the next step when the delivery was not confirmed in time, zero disables it
+	Timeout time.Duration `json:"timeout"`
+}
+
+func (a *application) SendWithFallback(id, locale, externalId string, params map[string]interface{}, steps []FallbackStep, options ...SendOption) error {
+	if len(steps) == 0 {
+		return errors.New("No fallback steps provided")
+	}
+
+	for _, step := range steps {
+		if !a.hasTransport(step.Type) {
+			return errors.Errorf("No transport configured for job type %s", step.Type)
+		}
+	}
+
+	tpl, err := a.resolveJobTemplate(id, locale)
+	if err != nil {
+		return err
+	}
+
+	params, err = applySchema(tpl, params)
+	if err != nil {
+		return err
+	}
+
+	chainUuid := uuid.New()
+
+	job := &Job{
+		Uuid:       uuid.New(),
+		ExternalId: externalId,
+		Type:       steps[0].Type,
+		TemplateId: id,
+		Locale:     locale,
+		Target:     steps[0].Target,
+		Params:     params,
+		ChainUuid:  &chainUuid,
+		Chain:      steps,
+		CreatedAt:  time.Now(),
+
+		template: tpl,
+	}
+
+	for _, option := range options {
+		option(job)
+	}
+
+	// Attachments, recipients and headers are kept on every job in the chain and only used by the email steps
+	if err := a.validateAttachments(job.Attachments); err != nil {
+		return err
+	}
+
+	if err := job.validateChain(); err != nil {
+		return err
+	}
+
+	if err := job.validateMetadata(); err != nil {
+		return err
+	}
+
+	if err := a.jobRepo.Create(job); err != nil {
+		return err
+	}
+
+	a.queue(job)
+
+	return nil
+}
+
+// validateChain validates the target of every step together with the recipients and headers used by it
+func (job *Job) validateChain() error {
+	hasEmail := false
+
+	for i, step := range job.Chain {
+		if step.Target == "" {
+			return errors.Errorf("Fallback step %d is missing a target", i)
+		}
+
+		if step.Timeout < 0 {
+			return errors.Errorf("Fallback step %d has a negative timeout", i)
+		}
+
+		probe := *job
+		probe.Type = step.Type
+		probe.Target = step.Target
+
+		if step.Type == JobEmail {
+			hasEmail = true
+		} else {
+			probe.To, probe.Cc, probe.Bcc, probe.Headers = nil, nil, nil, nil
+		}
+
+		if err := probe.validateRecipients(); err != nil {
+			return errors.Wrapf(err, "Invalid fallback step %d", i)
+		}
+
+		if err := probe.validateHeaders(); err != nil {
+			return errors.Wrapf(err, "Invalid fallback step %d", i)
+		}
+	}
+
+	if hasEmail {
+		return nil
+	}
+
+	// Without an email step the additional recipients and headers would never be used
+	if err := job.validateRecipients(); err != nil {
+		return err
+	}
+
+	return job.validateHeaders()
+}
+
+func (a *application) ConfirmDelivery(jobUuid uuid.UUID) error {
+	job, err := a.jobRepo.Get(jobUuid)
+	if err != nil {
+		return err
+	}
+
+	if job.DeliveredAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	job.DeliveredAt = &now
+	job.EscalateAt = nil
+
+	return a.jobRepo.Update(&job)
+}
+
+// handleFailure escalates a failed job to the next step if the chain asks for it
+func (a *application) handleFailure(job *Job, err error) {
+	if job.ChainStep >= len(job.Chain) || !job.Chain[job.ChainStep].OnError {
+		return
+	}
+
+	now := time.Now()
+
+	job.FailedAt = &now
+	job.Error = err.Error()
+
+	if err := a.jobRepo.Update(job); err != nil {
+		a.logger.
+			WithField("job", job).
+			WithError(err).
+			Error("failed to update job in transaction repo")
+	}
+
+	a.escalate(job)
+}
+
+// escalationDeadline returns when a sent job escalates to the next step unless its delivery was confirmed
+func (job *Job) escalationDeadline(sentAt time.Time) *time.Time {
+	if job.ChainStep >= len(job.Chain)-1 {
+		return nil
+	}
+
+	timeout := job.Chain[job.ChainStep].Timeout
+	if timeout <= 0 {
+		return nil
+	}
+
+	deadline := sentAt.Add(timeout)
+
+	return &deadline
+}
+
+// handleSent schedules the delivery check for a sent job in a fallback chain.
+// The deadline is stored on the job, so pending checks are resumed by resumeEscalations after a restart.
+func (a *application) handleSent(job *Job) {
+	if job.EscalateAt == nil {
+		return
+	}
+
+	jobUuid := job.Uuid
+
+	time.AfterFunc(time.Until(*job.EscalateAt), func() {
+		if a.workerCtx.Err() != nil {
+			return
+		}
+
+		job, err := a.jobRepo.Get(jobUuid)
+		if err != nil {
+			a.logger.
+				WithField("job", jobUuid).
+				WithError(err).
+				Error("failed to retrieve job for delivery check")
+
+			return
+		}
+
+		// Delivered, or already escalated by a previous check
+		if job.DeliveredAt != nil || job.EscalateAt == nil {
+			return
+		}
+
+		job.EscalateAt = nil
+
+		if err := a.jobRepo.Update(&job); err != nil {
+			a.logger.
+				WithField("job", job).
+				WithError(err).
+				Error("failed to update job in transaction repo")
+
+			return
+		}
+
+		a.escalate(&job)
+	})
+}
+
+// resumeEscalations schedules the delivery checks that were pending when the application was stopped
+func (a *application) resumeEscalations() error {
+	criteria := JobCriteria{
+		Limit:      100,
+		Escalating: true,
+		Sorting:    map[string]string{"uuid": "asc"},
+	}
+
+	for {
+		jobs, _, err := a.jobRepo.Matching(criteria)
+		if err != nil {
+			return err
+		}
+
+		for _, job := range jobs {
+			cpy := job
+
+			a.handleSent(&cpy)
+		}
+
+		if len(jobs) < criteria.Limit {
+			return nil
+		}
+
+		criteria.Offset += criteria.Limit
+	}
+}
+
+func (a *application) escalate(job *Job) {
+	next := job.ChainStep + 1
+	if next >= len(job.Chain) {
+		a.logger.
+			WithField("job", job).
+			Warn("fallback chain exhausted")
+
+		return
+	}
+
+	step := job.Chain[next]
+	parentUuid := job.Uuid
+
+	child := &Job{
+		Uuid:       uuid.New(),
+		ExternalId: job.ExternalId,
+		Type:       step.Type,
+		TemplateId: job.TemplateId,
+		Locale:     job.Locale,
+		Target:     step.Target,
+		Params:     job.Params,
+
+		To:  job.To,
+		Cc:  job.Cc,
+		Bcc: job.Bcc,
+
+		Attachments: job.Attachments,
+
+		Headers:  job.Headers,
+		Metadata: job.Metadata,
+		Tags:     job.Tags,
+
+		ChainUuid:  job.ChainUuid,
+		ParentUuid: &parentUuid,
+		ChainStep:  next,
+		Chain:      job.Chain,
+		CreatedAt:  time.Now(),
+	}
+
+	if err := a.jobRepo.Create(child); err != nil {
+		a.logger.
+			WithField("job", child).
+			WithError(err).
+			Error("failed to create fallback job")
+
+		return
+	}
+
+	a.queue(child)
+}
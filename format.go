@@ -0,0 +1,77 @@
+package communication
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/russross/blackfriday/v2"
+)
+
+// BodyFormat is the authoring format of the html body of a template
+type BodyFormat string
+
+const (
+	BodyFormatHtml     BodyFormat = "html"
+	BodyFormatMarkdown BodyFormat = "markdown"
+	BodyFormatMjml     BodyFormat = "mjml"
+)
+
+// BodyCompiler compiles a html body written in another format to html before it is templated
+type BodyCompiler interface {
+	Compile(body string) (string, error)
+}
+
+// BodyCompilerFunc allows a plain function to be used as a body compiler
+type BodyCompilerFunc func(body string) (string, error)
+
+func (f BodyCompilerFunc) Compile(body string) (string, error) {
+	return f(body)
+}
+
+// SetBodyCompiler registers the compiler for a body format, markdown is supported out of the box
+func SetBodyCompiler(format BodyFormat, compiler BodyCompiler) AppOption {
+	return func(a *application) {
+		a.bodyCompilers[format] = compiler
+	}
+}
+
+// MarkdownCompiler compiles markdown to html
+var MarkdownCompiler = BodyCompilerFunc(func(body string) (string, error) {
+	return string(blackfriday.Run([]byte(body), blackfriday.WithExtensions(blackfriday.CommonExtensions))), nil
+})
+
+var templateActionRegexp = regexp.MustCompile(`(?s){{.*?}}`)
+
+// compileHtmlBody returns the html body of the template compiled from its body format
+func (a *application) compileHtmlBody(template Template) (string, error) {
+	if template.BodyFormat == "" || template.BodyFormat == BodyFormatHtml {
+		return template.HtmlBody, nil
+	}
+
+	compiler, ok := a.bodyCompilers[template.BodyFormat]
+	if !ok {
+		return "", errors.Errorf("No compiler configured for body format %s", template.BodyFormat)
+	}
+
+	// Compilers escape quotes and other characters used in template actions,
+	// so actions are replaced with placeholders while compiling
+	var actions []string
+
+	body := templateActionRegexp.ReplaceAllStringFunc(template.HtmlBody, func(action string) string {
+		actions = append(actions, action)
+		return fmt.Sprintf("GOTEMPLATEACTION%dX", len(actions)-1)
+	})
+
+	compiled, err := compiler.Compile(body)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to compile %s body", template.BodyFormat)
+	}
+
+	for i, action := range actions {
+		compiled = strings.Replace(compiled, fmt.Sprintf("GOTEMPLATEACTION%dX", i), action, -1)
+	}
+
+	return compiled, nil
+}
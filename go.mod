@@ -1,8 +1,9 @@
 module github.com/interactive-solutions/go-communication
 
-go 1.12
+go 1.16
 
 require (
+	github.com/andybalholm/cascadia v1.0.0
 	github.com/aws/aws-sdk-go v1.17.14
 	github.com/go-pg/pg v6.15.1+incompatible
 	github.com/google/uuid v1.1.1
@@ -14,9 +15,13 @@ require (
 	github.com/onsi/ginkgo v1.8.0 // indirect
 	github.com/onsi/gomega v1.4.3 // indirect
 	github.com/pkg/errors v0.8.1
+	github.com/russross/blackfriday/v2 v2.0.1
+	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
 	github.com/sirupsen/logrus v1.3.0
 	github.com/stretchr/testify v1.3.0
-	golang.org/x/net v0.0.0-20190301231341-16b79f2e4e95 // indirect
+	golang.org/x/net v0.0.0-20190301231341-16b79f2e4e95
+	golang.org/x/text v0.3.0
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	mellium.im/sasl v0.2.1 // indirect
+	sigs.k8s.io/yaml v1.1.0
 )
@@ -0,0 +1,252 @@
+package communication
+
+import (
+	"sort"
+	"text/template/parse"
+)
+
+// TemplateHealth lists the problems found for the locales of a content template
+type TemplateHealth struct {
+	TemplateId string `json:"id"`
+	Healthy    bool   `json:"healthy"`
+
+	MissingLocales     []string `json:"missingLocales"`
+	DisabledLocales    []string `json:"disabledLocales"`
+	PlaceholderLocales []string `json:"placeholderLocales"`
+
+	// RenderErrors are the errors rendering each locale with its sample parameters
+	RenderErrors map[string]string `json:"renderErrors"`
+	// MissingSampleParameters are the parameters referenced by each locale missing from its sample parameters
+	MissingSampleParameters map[string][]string `json:"missingSampleParameters"`
+	// InconsistentParameters are the parameters referenced in some locales but not others, with the locales referencing them
+	InconsistentParameters map[string][]string `json:"inconsistentParameters"`
+}
+
+// TemplateHealthReport is the health of all content templates across the expected locales
+type TemplateHealthReport struct {
+	Locales   []string         `json:"locales"`
+	Templates []TemplateHealth `json:"templates"`
+}
+
+// TemplateHealth scans the content templates for missing translations and templates that will not render as
+// expected, every template is expected in the given locales or, when none are given, every locale in use
+func (a *application) TemplateHealth(locales ...string) (TemplateHealthReport, error) {
+	report := TemplateHealthReport{
+		Locales:   locales,
+		Templates: []TemplateHealth{},
+	}
+
+	templates, err := allTemplates(a.templateRepo, TemplateCriteria{Kind: string(TemplateKindContent)})
+	if err != nil {
+		return report, err
+	}
+
+	byId := map[string][]Template{}
+
+	for _, template := range templates {
+		byId[template.TemplateId] = append(byId[template.TemplateId], template)
+	}
+
+	if len(report.Locales) == 0 {
+		seen := map[string]bool{}
+
+		for _, template := range templates {
+			if !seen[template.Locale] {
+				seen[template.Locale] = true
+				report.Locales = append(report.Locales, template.Locale)
+			}
+		}
+
+		sort.Strings(report.Locales)
+	}
+
+	for templateId, translations := range byId {
+		report.Templates = append(report.Templates, a.templateHealth(templateId, translations, report.Locales))
+	}
+
+	sort.Slice(report.Templates, func(i, j int) bool {
+		return report.Templates[i].TemplateId < report.Templates[j].TemplateId
+	})
+
+	return report, nil
+}
+
+func (a *application) templateHealth(templateId string, translations []Template, locales []string) TemplateHealth {
+	health := TemplateHealth{
+		TemplateId:              templateId,
+		MissingLocales:          []string{},
+		DisabledLocales:         []string{},
+		PlaceholderLocales:      []string{},
+		RenderErrors:            map[string]string{},
+		MissingSampleParameters: map[string][]string{},
+		InconsistentParameters:  map[string][]string{},
+	}
+
+	sort.Slice(translations, func(i, j int) bool {
+		return translations[i].Locale < translations[j].Locale
+	})
+
+	present := map[string]bool{}
+	referencedBy := map[string][]string{}
+	translated := 0
+
+	for _, template := range translations {
+		present[template.Locale] = true
+
+		if template.Subject == placeholderSubject {
+			health.PlaceholderLocales = append(health.PlaceholderLocales, template.Locale)
+			continue
+		}
+
+		if !template.Enabled {
+			health.DisabledLocales = append(health.DisabledLocales, template.Locale)
+		}
+
+		params := a.params(template.Schema.Example(template.Parameters))
+
+		if _, _, _, err := a.renderAll(template, &Job{Params: params}); err != nil {
+			health.RenderErrors[template.Locale] = err.Error()
+		}
+
+		referenced, err := a.referencedParameters(template)
+		if err != nil {
+			health.RenderErrors[template.Locale] = err.Error()
+			continue
+		}
+
+		// Only locales whose parameters are known take part in the consistency check
+		translated++
+
+		for _, name := range referenced {
+			referencedBy[name] = append(referencedBy[name], template.Locale)
+
+			if _, ok := params[name]; !ok {
+				health.MissingSampleParameters[template.Locale] = append(health.MissingSampleParameters[template.Locale], name)
+			}
+		}
+	}
+
+	for _, locale := range locales {
+		if !present[locale] {
+			health.MissingLocales = append(health.MissingLocales, locale)
+		}
+	}
+
+	for name, referencing := range referencedBy {
+		if len(referencing) < translated {
+			health.InconsistentParameters[name] = referencing
+		}
+	}
+
+	health.Healthy = len(health.MissingLocales) == 0 &&
+		len(health.DisabledLocales) == 0 &&
+		len(health.PlaceholderLocales) == 0 &&
+		len(health.RenderErrors) == 0 &&
+		len(health.MissingSampleParameters) == 0 &&
+		len(health.InconsistentParameters) == 0
+
+	return health
+}
+
+// referencedParameters returns the top level parameters used by the subject and bodies of the template,
+// including its layout and partials, parsed the same way as when rendering
+func (a *application) referencedParameters(template Template) ([]string, error) {
+	compiled, err := a.compile(template)
+	if err != nil {
+		return nil, err
+	}
+
+	var trees []*parse.Tree
+
+	for _, t := range append(compiled.subject.Templates(), compiled.text.Templates()...) {
+		trees = append(trees, t.Tree)
+	}
+
+	for _, t := range compiled.html.Templates() {
+		trees = append(trees, t.Tree)
+	}
+
+	seen := map[string]bool{}
+
+	var referenced []string
+
+	for _, tree := range trees {
+		if tree == nil {
+			continue
+		}
+
+		collectParameters(tree.Root, true, func(name string) {
+			if !seen[name] {
+				seen[name] = true
+				referenced = append(referenced, name)
+			}
+		})
+	}
+
+	sort.Strings(referenced)
+
+	return referenced, nil
+}
+
+// collectParameters reports the fields accessed on the parameters, root is false where range
+// and with have changed dot to something other than the parameters
+func collectParameters(node parse.Node, root bool, fn func(name string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+
+		for _, child := range n.Nodes {
+			collectParameters(child, root, fn)
+		}
+
+	case *parse.ActionNode:
+		collectParameters(n.Pipe, root, fn)
+
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+
+		for _, cmd := range n.Cmds {
+			collectParameters(cmd, root, fn)
+		}
+
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectParameters(arg, root, fn)
+		}
+
+	case *parse.ChainNode:
+		collectParameters(n.Node, root, fn)
+
+	case *parse.FieldNode:
+		if root {
+			fn(n.Ident[0])
+		}
+
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			fn(n.Ident[1])
+		}
+
+	case *parse.IfNode:
+		collectParameters(n.Pipe, root, fn)
+		collectParameters(n.List, root, fn)
+		collectParameters(n.ElseList, root, fn)
+
+	case *parse.RangeNode:
+		collectParameters(n.Pipe, root, fn)
+		collectParameters(n.List, false, fn)
+		collectParameters(n.ElseList, root, fn)
+
+	case *parse.WithNode:
+		collectParameters(n.Pipe, root, fn)
+		collectParameters(n.List, false, fn)
+		collectParameters(n.ElseList, root, fn)
+
+	case *parse.TemplateNode:
+		collectParameters(n.Pipe, root, fn)
+	}
+}
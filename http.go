@@ -4,11 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/interactive-solutions/go-communication/internal"
+	"github.com/pkg/errors"
 )
 
 type HttpHandler struct {
@@ -21,6 +25,29 @@ type collectionMeta struct {
 	Offset int `json:"offset"`
 }
 
+// templateResponse is a changed template together with the sms analysis of its sample text body
+type templateResponse struct {
+	Template
+
+	Sms      SmsAnalysis `json:"sms"`
+	SmsError string      `json:"smsError,omitempty"`
+}
+
+func newTemplateResponse(template Template, text string) templateResponse {
+	_, analysis, err := limitSms(template, text)
+
+	response := templateResponse{
+		Template: template,
+		Sms:      analysis,
+	}
+
+	if err != nil {
+		response.SmsError = err.Error()
+	}
+
+	return response
+}
+
 func (h *HttpHandler) TestTemplate(w http.ResponseWriter, r *http.Request) {
 
 	body := &internal.TestTemplateRequest{}
@@ -46,18 +73,42 @@ func (h *HttpHandler) TestTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	switch body.Type {
-	case "sms":
-		h.app.SendSms(template.TemplateId, template.Locale, body.Target, "", template.Parameters)
+	var options []SendOption
+	if body.Draft {
+		options = append(options, useDraft())
+	}
+
+	jobType := JobType(body.Type)
 
-	case "email":
-		h.app.SendEmail(template.TemplateId, template.Locale, body.Target, "", template.Parameters)
+	switch jobType {
+	case JobSms, JobEmail:
 
 	default:
 		http.Error(w, fmt.Sprintf("Unsupported type %s", body.Type), http.StatusBadRequest)
 		return
 	}
 
+	if !h.app.hasTransport(jobType) {
+		http.Error(w, fmt.Sprintf("No %s transport configured", jobType), 500)
+		return
+	}
+
+	job, err := h.app.newJob(jobType, template.TemplateId, template.Locale, body.Target, "", template.Parameters, options)
+	if err != nil {
+		if errors.Cause(err) == TemplateNotFoundErr {
+			http.Error(w, "Template not found", 404)
+			return
+		}
+
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	if err := h.app.enqueue(job); err != nil {
+		http.Error(w, "Failed to send template", 500)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -158,28 +209,63 @@ func (h *HttpHandler) UpdateTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	previous := template
+
 	template.Subject = body.Subject
 	template.TextBody = body.TextBody
 	template.HtmlBody = body.HtmlBody
 	template.UpdateParameters = body.UpdateParameters
 	template.Enabled = body.Enabled
+	template.Layout = body.Layout
+	template.BodyFormat = BodyFormat(body.BodyFormat)
+	template.FromName = body.FromName
+	template.FromAddress = body.FromAddress
+	template.ReplyTo = body.ReplyTo
+	template.SmsSender = body.SmsSender
+	template.SmsMaxSegments = body.SmsMaxSegments
+	template.SmsLimitAction = SmsLimitAction(body.SmsLimitAction)
+
+	if err := decodeSchema(body.Schema, &template); err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
 
 	// Check if we have a html to text converter if the text body was not provided
 	if template.TextBody == "" && h.app.htmlToTextConverter != nil {
-		template.TextBody = h.app.htmlToTextConverter(template.HtmlBody)
+		html, err := h.app.compileHtmlBody(template)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to compile html body with error: %s", err.Error()), 422)
+			return
+		}
+
+		template.TextBody = h.app.htmlToTextConverter(html)
 	}
 
-	if _, _, _, err := h.app.Render(template, &Job{Params: template.Parameters}); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to render template with error: %s", err.Error()), 422)
+	text, err := h.app.validateTemplate(template)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
 		return
 	}
 
-	if err := h.app.templateRepo.Update(&template); err != nil {
+	var version *TemplateVersion
+
+	if h.app.draftWorkflow {
+		template = previous.withDraft(template, h.app.author(r))
+	} else {
+		template.Version++
+
+		published := newTemplateVersion(template)
+		published.Author = h.app.author(r)
+
+		version = &published
+	}
+
+	if err := h.app.saveTemplate(previous, &template, version); err != nil {
 		http.Error(w, "Failed to update template", 500)
 		return
 	}
 
-	data, err := json.Marshal(template)
+	data, err := json.Marshal(newTemplateResponse(template, text))
 	if err != nil {
 		http.Error(w, "Failed to convert template to json", 500)
 		return
@@ -189,7 +275,8 @@ func (h *HttpHandler) UpdateTemplate(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-func (h *HttpHandler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+// PreviewTemplate renders a template, or unsaved content for it, without sending or storing anything
+func (h *HttpHandler) PreviewTemplate(w http.ResponseWriter, r *http.Request) {
 	id, ok := mux.Vars(r)["id"]
 	if !ok {
 		http.Error(w, "Route id var", 400)
@@ -202,8 +289,14 @@ func (h *HttpHandler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	body := &internal.PreviewTemplateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		http.Error(w, "Failed to parse incoming json", 400)
+		return
+	}
+
 	template, err := h.app.templateRepo.Get(split[1], split[0])
-	if err != nil {
+	if err != nil && (err != TemplateNotFoundErr || body.Template == nil) {
 		if err == TemplateNotFoundErr {
 			http.Error(w, "Template not found", 404)
 			return
@@ -213,87 +306,972 @@ func (h *HttpHandler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.app.templateRepo.Delete(&template); err != nil {
-		http.Error(w, "Failed to delete template", 500)
+	template.TemplateId = split[1]
+	template.Locale = split[0]
+
+	if body.Draft {
+		template = template.drafted()
+	}
+
+	if content := body.Template; content != nil {
+		template.Layout = content.Layout
+		template.BodyFormat = BodyFormat(content.BodyFormat)
+		template.Subject = content.Subject
+		template.TextBody = content.TextBody
+		template.HtmlBody = content.HtmlBody
+		template.SmsMaxSegments = content.SmsMaxSegments
+		template.SmsLimitAction = SmsLimitAction(content.SmsLimitAction)
+
+		if err := decodeSchema(content.Schema, &template); err != nil {
+			http.Error(w, err.Error(), 422)
+			return
+		}
+
+		if template.TextBody == "" && h.app.htmlToTextConverter != nil {
+			html, err := h.app.compileHtmlBody(template)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to compile html body with error: %s", err.Error()), 422)
+				return
+			}
+
+			template.TextBody = h.app.htmlToTextConverter(html)
+		}
+	}
+
+	params := template.Schema.Example(template.Parameters)
+
+	if body.Parameters != nil {
+		if params, err = template.Schema.Apply(template.TemplateId, body.Parameters); err != nil {
+			http.Error(w, err.Error(), 422)
+			return
+		}
+	}
+
+	var preview struct {
+		RenderedTemplate
+
+		// SmsBody is the text body as it would be sent as sms, after applying the segment limit
+		SmsBody  string      `json:"smsBody"`
+		Sms      SmsAnalysis `json:"sms"`
+		SmsError string      `json:"smsError,omitempty"`
+	}
+
+	preview.Locale = template.Locale
+	preview.Subject, preview.TextBody, preview.HtmlBody, err = h.app.renderAll(template, &Job{Params: params})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render template with error: %s", err.Error()), 422)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	if h.app.cssInlining {
+		if preview.HtmlBody, err = inlineCss(preview.HtmlBody); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to inline css with error: %s", err.Error()), 422)
+			return
+		}
+	}
+
+	if preview.SmsBody, preview.Sms, err = limitSms(template, preview.TextBody); err != nil {
+		preview.SmsError = err.Error()
+	}
+
+	data, err := json.Marshal(preview)
+	if err != nil {
+		http.Error(w, "Failed to convert preview to json", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
 }
 
-func (h *HttpHandler) GetEmailUnsubscriptions(w http.ResponseWriter, r *http.Request) {
-	email, ok := mux.Vars(r)["email"]
+func (h *HttpHandler) GetTemplateSchema(w http.ResponseWriter, r *http.Request) {
+	id, ok := mux.Vars(r)["id"]
 	if !ok {
-		http.Error(w, "email arg missing in route definition", 422)
+		http.Error(w, "Route id var", 400)
 		return
 	}
 
-	transport, ok := h.app.defaultEmailTransport.(TransportSupportsSubscriptionBlocking)
-	if !ok {
-		http.Error(w, "Transport does not support manage subscriptions", 500)
+	split := strings.SplitN(id, ":", 2)
+	if len(split) != 2 {
+		http.Error(w, "Invalid id provided, templateId:locale expected", 400)
 		return
 	}
 
-	templates, err := transport.GetUnsubscribedTemplates(r.Context(), email)
+	template, err := h.app.templateRepo.Get(split[1], split[0])
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to retrieve unsubscribed templates from transport: %s", err.Error()), 500)
+		if err == TemplateNotFoundErr {
+			http.Error(w, "Template not found", 404)
+			return
+		}
+
+		http.Error(w, "Failed to retrieve template", 500)
 		return
 	}
 
+	schema := template.Schema
+	if schema == nil {
+		schema = ParameterSchema{}
+	}
+
 	payload := struct {
-		Templates []string `json:"templates"`
-	}{Templates: templates}
+		Schema     ParameterSchema        `json:"schema"`
+		Parameters map[string]interface{} `json:"parameters"`
+	}{
+		Schema:     schema,
+		Parameters: template.Parameters,
+	}
 
-	if err := json.NewEncoder(w).Encode(payload); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to encode payload to json: %s", err.Error()), 500)
+	data, err := json.Marshal(payload)
+	if err != nil {
+		http.Error(w, "Failed to convert schema to json", 500)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
 }
 
-func (h *HttpHandler) Resubscribe(w http.ResponseWriter, r *http.Request) {
-	transport, ok := h.app.defaultEmailTransport.(TransportSupportsSubscriptionBlocking)
-	if !ok {
-		http.Error(w, "Transport does not support manage subscriptions", 500)
+func (h *HttpHandler) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	body := &internal.CreateTemplateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		http.Error(w, "Failed to parse incoming json", 400)
 		return
 	}
 
-	body := &internal.ResubscribeRequest{}
-	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
-		http.Error(w, "Failed to parse incoming json", 400)
+	if body.Id == "" || body.Locale == "" {
+		http.Error(w, "Both id and locale are required", 422)
 		return
 	}
 
-	if len(body.Templates) == 0 {
-		if err := transport.ResubscribeToAll(r.Context(), body.Email); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to resubscribe to all templates: %s", err.Error()), 500)
-		} else {
-			w.WriteHeader(http.StatusNoContent)
+	kind := TemplateKind(body.Kind)
+
+	switch kind {
+	case "":
+		kind = TemplateKindContent
+
+	case TemplateKindContent, TemplateKindLayout, TemplateKindPartial:
+
+	default:
+		http.Error(w, fmt.Sprintf("Unsupported kind %s", body.Kind), 422)
+		return
+	}
+
+	if _, err := h.app.templateRepo.Get(body.Id, body.Locale); err == nil {
+		http.Error(w, "Template already exists", http.StatusConflict)
+		return
+	} else if err != TemplateNotFoundErr {
+		http.Error(w, "Failed to retrieve template", 500)
+		return
+	}
+
+	template := Template{
+		TemplateId:       body.Id,
+		Locale:           body.Locale,
+		Kind:             kind,
+		Layout:           body.Layout,
+		Enabled:          body.Enabled,
+		Description:      body.Description,
+		UpdateParameters: body.UpdateParameters,
+
+		Subject:    body.Subject,
+		TextBody:   body.TextBody,
+		HtmlBody:   body.HtmlBody,
+		BodyFormat: BodyFormat(body.BodyFormat),
+
+		FromName:    body.FromName,
+		FromAddress: body.FromAddress,
+		ReplyTo:     body.ReplyTo,
+		SmsSender:   body.SmsSender,
+
+		SmsMaxSegments: body.SmsMaxSegments,
+		SmsLimitAction: SmsLimitAction(body.SmsLimitAction),
+
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if err := decodeSchema(body.Schema, &template); err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	if template.TextBody == "" && h.app.htmlToTextConverter != nil && kind == TemplateKindContent {
+		html, err := h.app.compileHtmlBody(template)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to compile html body with error: %s", err.Error()), 422)
+			return
 		}
 
+		template.TextBody = h.app.htmlToTextConverter(html)
+	}
+
+	text, err := h.app.validateTemplate(template)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
 		return
 	}
 
-	var wg sync.WaitGroup
-	var err error
+	var version *TemplateVersion
 
-	for _, template := range body.Templates {
-		wg.Add(1)
+	if h.app.draftWorkflow {
+		template = newDraftTemplate(template, h.app.author(r))
+	} else {
+		template.Version = 1
 
-		go func(t string) {
-			defer wg.Done()
+		published := newTemplateVersion(template)
+		published.Author = h.app.author(r)
 
-			if e := transport.ResubscribeToTemplate(r.Context(), body.Email, t); e != nil {
-				err = e
-			}
-		}(template)
+		version = &published
 	}
 
-	wg.Wait()
+	if err := h.app.createTemplate(&template, version); err != nil {
+		http.Error(w, "Failed to create template", 500)
+		return
+	}
 
+	data, err := json.Marshal(newTemplateResponse(template, text))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to resubscribe to a template: %s", err.Error()), 500)
+		http.Error(w, "Failed to convert template to json", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(data)
+}
+
+func (h *HttpHandler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		http.Error(w, "Route id var", 400)
+		return
+	}
+
+	split := strings.SplitN(id, ":", 2)
+	if len(split) != 2 {
+		http.Error(w, "Invalid id provided, templateId:locale expected", 400)
+		return
+	}
+
+	template, err := h.app.templateRepo.Get(split[1], split[0])
+	if err != nil {
+		if err == TemplateNotFoundErr {
+			http.Error(w, "Template not found", 404)
+			return
+		}
+
+		http.Error(w, "Failed to retrieve template", 500)
+		return
+	}
+
+	if err := h.app.templateRepo.Delete(&template); err != nil {
+		http.Error(w, "Failed to delete template", 500)
 		return
 	}
 
+	h.app.invalidateTemplate(template.TemplateId, template.Locale)
+
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (h *HttpHandler) GetTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	if h.app.templateVersionRepo == nil {
+		http.Error(w, "Template versioning is not configured", 500)
+		return
+	}
+
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		http.Error(w, "Route id var", 400)
+		return
+	}
+
+	split := strings.SplitN(id, ":", 2)
+	if len(split) != 2 {
+		http.Error(w, "Invalid id provided, templateId:locale expected", 400)
+		return
+	}
+
+	criteria := PopulateTemplateVersionCriteria(r)
+	criteria.TemplateId = split[1]
+	criteria.Locale = split[0]
+
+	versions, count, err := h.app.templateVersionRepo.Matching(criteria)
+	if err != nil {
+		http.Error(w, "Failed to retrieve template versions", 500)
+		return
+	}
+
+	payload := struct {
+		Data []TemplateVersion `json:"data"`
+		Meta collectionMeta    `json:"meta"`
+	}{
+		Data: versions,
+		Meta: collectionMeta{
+			Total:  count,
+			Limit:  criteria.Limit,
+			Offset: criteria.Offset,
+		},
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		http.Error(w, "Failed to convert to json", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (h *HttpHandler) GetTemplateVersion(w http.ResponseWriter, r *http.Request) {
+	version, ok := h.getTemplateVersion(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := json.Marshal(version)
+	if err != nil {
+		http.Error(w, "Failed to convert template version to json", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// DiffTemplateVersion returns the fields changed by a version, compared to the version set in
+// the against query parameter or to the previous version by default
+func (h *HttpHandler) DiffTemplateVersion(w http.ResponseWriter, r *http.Request) {
+	version, ok := h.getTemplateVersion(w, r)
+	if !ok {
+		return
+	}
+
+	against := version.Version - 1
+	explicit := r.FormValue("against") != ""
+
+	if explicit {
+		number, err := strconv.Atoi(r.FormValue("against"))
+		if err != nil {
+			http.Error(w, "Invalid against version provided", 400)
+			return
+		}
+
+		against = number
+	}
+
+	base, err := h.app.templateVersionRepo.Get(version.TemplateId, version.Locale, against)
+	switch {
+	case err == TemplateVersionNotFoundErr && !explicit:
+		// The first version is compared to an empty template
+		base = TemplateVersion{TemplateId: version.TemplateId, Locale: version.Locale}
+
+	case err == TemplateVersionNotFoundErr:
+		http.Error(w, "Template version to compare against not found", 404)
+		return
+
+	case err != nil:
+		http.Error(w, "Failed to retrieve template version", 500)
+		return
+	}
+
+	data, err := json.Marshal(DiffTemplateVersions(base, version))
+	if err != nil {
+		http.Error(w, "Failed to convert template version diff to json", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// RollbackTemplate restores the content of a previous version as a new version, or as the draft when using the draft workflow
+func (h *HttpHandler) RollbackTemplate(w http.ResponseWriter, r *http.Request) {
+	version, ok := h.getTemplateVersion(w, r)
+	if !ok {
+		return
+	}
+
+	template, err := h.app.templateRepo.Get(version.TemplateId, version.Locale)
+	if err != nil {
+		if err == TemplateNotFoundErr {
+			http.Error(w, "Template not found", 404)
+			return
+		}
+
+		http.Error(w, "Failed to retrieve template", 500)
+		return
+	}
+
+	previous := template
+
+	restored := template
+	version.restore(&restored)
+
+	var published *TemplateVersion
+
+	// Rollbacks are published like any other change when using the draft workflow
+	if h.app.draftWorkflow {
+		template = previous.withDraft(restored, h.app.author(r))
+		template.Draft.RestoredFrom = &version.Version
+	} else {
+		// Versions may predate changes to validation, layouts or partials
+		if _, err := h.app.validateTemplate(restored); err != nil {
+			http.Error(w, err.Error(), 422)
+			return
+		}
+
+		template = restored
+		template.Version++
+
+		restoredVersion := newTemplateVersion(template)
+		restoredVersion.Author = h.app.author(r)
+		restoredVersion.RestoredFrom = &version.Version
+
+		published = &restoredVersion
+	}
+
+	if err := h.app.saveTemplate(previous, &template, published); err != nil {
+		http.Error(w, "Failed to update template", 500)
+		return
+	}
+
+	data, err := json.Marshal(template)
+	if err != nil {
+		http.Error(w, "Failed to convert template to json", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// PublishTemplateDraft makes the draft of a template the content sent to customers
+func (h *HttpHandler) PublishTemplateDraft(w http.ResponseWriter, r *http.Request) {
+	template, ok := h.getTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	if template.Draft == nil {
+		http.Error(w, "Template has no draft", 409)
+		return
+	}
+
+	publisher := h.app.author(r)
+	if h.app.publishApproval && (publisher == "" || publisher == template.Draft.Author) {
+		http.Error(w, "The draft has to be published by someone other than its author", 403)
+		return
+	}
+
+	previous := template
+
+	template = template.drafted()
+	template.Enabled = previous.Draft.Enabled
+	template.Draft = nil
+	template.Version = previous.Version + 1
+
+	if _, err := h.app.validateTemplate(template); err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	version := *previous.Draft
+	version.Enabled = false
+	version.Version = template.Version
+	version.CreatedAt = time.Now()
+
+	if h.app.publishApproval {
+		version.ApprovedBy = publisher
+	}
+
+	if err := h.app.saveTemplate(previous, &template, &version); err != nil {
+		http.Error(w, "Failed to update template", 500)
+		return
+	}
+
+	data, err := json.Marshal(template)
+	if err != nil {
+		http.Error(w, "Failed to convert template to json", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (h *HttpHandler) DiscardTemplateDraft(w http.ResponseWriter, r *http.Request) {
+	template, ok := h.getTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	if template.Draft == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	template.Draft = nil
+
+	if err := h.app.saveTemplate(template, &template, nil); err != nil {
+		http.Error(w, "Failed to update template", 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExportTemplates writes the templates matching the criteria as a json or yaml bundle
+func (h *HttpHandler) ExportTemplates(w http.ResponseWriter, r *http.Request) {
+	format := BundleFormat(r.FormValue("format"))
+	if format == "" {
+		format = BundleFormatJson
+	}
+
+	if format != BundleFormatJson && format != BundleFormatYaml {
+		http.Error(w, fmt.Sprintf("Unsupported format %s", format), 400)
+		return
+	}
+
+	bundle, err := ExportTemplates(h.app.templateRepo, PopulateTemplateCriteria(r))
+	if err != nil {
+		http.Error(w, "Failed to export templates", 500)
+		return
+	}
+
+	contentType := "application/json"
+	if format == BundleFormatYaml {
+		contentType = "application/x-yaml"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="templates.%s"`, format))
+
+	if err := EncodeTemplateBundle(w, bundle, format); err != nil {
+		h.app.logger.WithError(err).Error("Failed to write template bundle")
+	}
+}
+
+// ImportTemplates imports a json or yaml bundle, with dryRun=true only the changes it would make are returned
+func (h *HttpHandler) ImportTemplates(w http.ResponseWriter, r *http.Request) {
+	format := BundleFormat(r.FormValue("format"))
+	if format == "" && strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+		format = BundleFormatYaml
+	}
+
+	bundle, err := DecodeTemplateBundle(r.Body, format)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if err := bundle.Validate(); err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	changes, err := h.app.ImportTemplates(bundle, r.FormValue("dryRun") == "true", h.app.author(r))
+	if _, ok := err.(*InvalidBundleError); ok {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	status := http.StatusOK
+
+	// The templates imported before a failure are reported so the import can be resumed
+	payload := struct {
+		Data  []TemplateChange `json:"data"`
+		Error string           `json:"error,omitempty"`
+	}{
+		Data: changes,
+	}
+
+	if err != nil {
+		h.app.logger.WithError(err).Error("Failed to import templates")
+
+		status = http.StatusInternalServerError
+		payload.Error = "Failed to import templates"
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		http.Error(w, "Failed to convert to json", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// GetTemplateHealth reports missing translations and broken templates, expected locales are given as ?locales=sv,en
+func (h *HttpHandler) GetTemplateHealth(w http.ResponseWriter, r *http.Request) {
+	var locales []string
+	if value := r.FormValue("locales"); value != "" {
+		locales = strings.Split(value, ",")
+	}
+
+	report, err := h.app.TemplateHealth(locales...)
+	if err != nil {
+		http.Error(w, "Failed to check template health", 500)
+		return
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, "Failed to convert to json", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (h *HttpHandler) GetEmailUnsubscriptions(w http.ResponseWriter, r *http.Request) {
+	email, ok := mux.Vars(r)["email"]
+	if !ok {
+		http.Error(w, "email arg missing in route definition", 422)
+		return
+	}
+
+	transport, ok := h.app.defaultEmailTransport.(TransportSupportsSubscriptionBlocking)
+	if !ok {
+		http.Error(w, "Transport does not support manage subscriptions", 500)
+		return
+	}
+
+	templates, err := transport.GetUnsubscribedTemplates(r.Context(), email)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to retrieve unsubscribed templates from transport: %s", err.Error()), 500)
+		return
+	}
+
+	payload := struct {
+		Templates []string `json:"templates"`
+	}{Templates: templates}
+
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode payload to json: %s", err.Error()), 500)
+		return
+	}
+}
+
+func (h *HttpHandler) Resubscribe(w http.ResponseWriter, r *http.Request) {
+	transport, ok := h.app.defaultEmailTransport.(TransportSupportsSubscriptionBlocking)
+	if !ok {
+		http.Error(w, "Transport does not support manage subscriptions", 500)
+		return
+	}
+
+	body := &internal.ResubscribeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		http.Error(w, "Failed to parse incoming json", 400)
+		return
+	}
+
+	if len(body.Templates) == 0 {
+		if err := transport.ResubscribeToAll(r.Context(), body.Email); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to resubscribe to all templates: %s", err.Error()), 500)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+		return
+	}
+
+	var wg sync.WaitGroup
+	var err error
+
+	for _, template := range body.Templates {
+		wg.Add(1)
+
+		go func(t string) {
+			defer wg.Done()
+
+			if e := transport.ResubscribeToTemplate(r.Context(), body.Email, t); e != nil {
+				err = e
+			}
+		}(template)
+	}
+
+	wg.Wait()
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to resubscribe to a template: %s", err.Error()), 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HttpHandler) ConfirmJobDelivery(w http.ResponseWriter, r *http.Request) {
+	id, ok := mux.Vars(r)["uuid"]
+	if !ok {
+		http.Error(w, "Route uuid var", 400)
+		return
+	}
+
+	jobUuid, err := uuid.Parse(id)
+	if err != nil {
+		http.Error(w, "Invalid uuid provided", 400)
+		return
+	}
+
+	if err := h.app.ConfirmDelivery(jobUuid); err != nil {
+		if err == JobNotFoundErr {
+			http.Error(w, "Job not found", 404)
+			return
+		}
+
+		http.Error(w, "Failed to confirm job delivery", 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HttpHandler) GetInboxMessages(w http.ResponseWriter, r *http.Request) {
+	if h.app.inboxRepo == nil {
+		http.Error(w, "Inbox is not configured", 500)
+		return
+	}
+
+	recipient, ok := mux.Vars(r)["recipient"]
+	if !ok {
+		http.Error(w, "recipient arg missing in route definition", 422)
+		return
+	}
+
+	criteria := PopulateInboxCriteria(r)
+	criteria.Recipient = recipient
+
+	messages, count, err := h.app.inboxRepo.Matching(criteria)
+	if err != nil {
+		http.Error(w, "Failed to retrieve inbox messages", 500)
+		return
+	}
+
+	payload := struct {
+		Data []InboxMessage `json:"data"`
+		Meta collectionMeta `json:"meta"`
+	}{
+		Data: messages,
+		Meta: collectionMeta{
+			Total:  count,
+			Limit:  criteria.Limit,
+			Offset: criteria.Offset,
+		},
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		http.Error(w, "Failed to convert to json", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (h *HttpHandler) GetInboxUnreadCount(w http.ResponseWriter, r *http.Request) {
+	if h.app.inboxRepo == nil {
+		http.Error(w, "Inbox is not configured", 500)
+		return
+	}
+
+	recipient, ok := mux.Vars(r)["recipient"]
+	if !ok {
+		http.Error(w, "recipient arg missing in route definition", 422)
+		return
+	}
+
+	count, err := h.app.inboxRepo.CountUnread(recipient)
+	if err != nil {
+		http.Error(w, "Failed to count unread inbox messages", 500)
+		return
+	}
+
+	payload := struct {
+		Unread int `json:"unread"`
+	}{Unread: count}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode payload to json: %s", err.Error()), 500)
+		return
+	}
+}
+
+func (h *HttpHandler) MarkInboxMessageRead(w http.ResponseWriter, r *http.Request) {
+	message, ok := h.getInboxMessage(w, r)
+	if !ok {
+		return
+	}
+
+	if message.ReadAt == nil {
+		now := time.Now()
+		message.ReadAt = &now
+
+		if err := h.app.inboxRepo.Update(&message); err != nil {
+			http.Error(w, "Failed to update inbox message", 500)
+			return
+		}
+
+		// Inbox messages share uuid with their job, reading one confirms the delivery
+		if err := h.app.ConfirmDelivery(message.Uuid); err != nil && err != JobNotFoundErr {
+			h.app.logger.
+				WithField("job", message.Uuid).
+				WithError(err).
+				Error("failed to confirm delivery of inbox message")
+		}
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		http.Error(w, "Failed to convert inbox message to json", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (h *HttpHandler) DeleteInboxMessage(w http.ResponseWriter, r *http.Request) {
+	message, ok := h.getInboxMessage(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.app.inboxRepo.Delete(&message); err != nil {
+		http.Error(w, "Failed to delete inbox message", 500)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HttpHandler) getInboxMessage(w http.ResponseWriter, r *http.Request) (InboxMessage, bool) {
+	if h.app.inboxRepo == nil {
+		http.Error(w, "Inbox is not configured", 500)
+		return InboxMessage{}, false
+	}
+
+	recipient, ok := mux.Vars(r)["recipient"]
+	if !ok {
+		http.Error(w, "recipient arg missing in route definition", 422)
+		return InboxMessage{}, false
+	}
+
+	id, ok := mux.Vars(r)["uuid"]
+	if !ok {
+		http.Error(w, "Route uuid var", 400)
+		return InboxMessage{}, false
+	}
+
+	messageUuid, err := uuid.Parse(id)
+	if err != nil {
+		http.Error(w, "Invalid uuid provided", 400)
+		return InboxMessage{}, false
+	}
+
+	// Messages of other recipients are reported as not found
+	message, err := h.app.inboxRepo.Get(messageUuid)
+	if err != nil || message.Recipient != recipient {
+		if err == nil || err == InboxMessageNotFoundErr {
+			http.Error(w, "Inbox message not found", 404)
+			return message, false
+		}
+
+		http.Error(w, "Failed to retrieve inbox message", 500)
+		return message, false
+	}
+
+	return message, true
+}
+
+func (h *HttpHandler) getTemplate(w http.ResponseWriter, r *http.Request) (Template, bool) {
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		http.Error(w, "Route id var", 400)
+		return Template{}, false
+	}
+
+	split := strings.SplitN(id, ":", 2)
+	if len(split) != 2 {
+		http.Error(w, "Invalid id provided, templateId:locale expected", 400)
+		return Template{}, false
+	}
+
+	template, err := h.app.templateRepo.Get(split[1], split[0])
+	if err != nil {
+		if err == TemplateNotFoundErr {
+			http.Error(w, "Template not found", 404)
+			return template, false
+		}
+
+		http.Error(w, "Failed to retrieve template", 500)
+		return template, false
+	}
+
+	return template, true
+}
+
+func (h *HttpHandler) getTemplateVersion(w http.ResponseWriter, r *http.Request) (TemplateVersion, bool) {
+	if h.app.templateVersionRepo == nil {
+		http.Error(w, "Template versioning is not configured", 500)
+		return TemplateVersion{}, false
+	}
+
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		http.Error(w, "Route id var", 400)
+		return TemplateVersion{}, false
+	}
+
+	split := strings.SplitN(id, ":", 2)
+	if len(split) != 2 {
+		http.Error(w, "Invalid id provided, templateId:locale expected", 400)
+		return TemplateVersion{}, false
+	}
+
+	number, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil {
+		http.Error(w, "Invalid version provided", 400)
+		return TemplateVersion{}, false
+	}
+
+	version, err := h.app.templateVersionRepo.Get(split[1], split[0], number)
+	if err != nil {
+		if err == TemplateVersionNotFoundErr {
+			http.Error(w, "Template version not found", 404)
+			return version, false
+		}
+
+		http.Error(w, "Failed to retrieve template version", 500)
+		return version, false
+	}
+
+	return version, true
+}
+
+// decodeSchema replaces the schema of the template when one was provided in the request
+func decodeSchema(raw json.RawMessage, template *Template) error {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var schema ParameterSchema
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return errors.Wrap(err, "Invalid parameter schema")
+	}
+
+	if err := schema.Validate(); err != nil {
+		return errors.Wrap(err, "Invalid parameter schema")
+	}
+
+	template.Schema = schema
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package communication
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// InboxMessage is a rendered notification stored for a recipient to read in-app
+type InboxMessage struct {
+	Uuid       uuid.UUID `sql:",pk" json:"uuid"`
+	Recipient  string    `sql:",notnull" json:"recipient"`
+	ExternalId string    `sql:",notnull" json:"externalId"`
+
+	TemplateId string `json:"templateId"`
+	Locale     string `json:"locale"`
+
+	Subject  string `json:"subject"`
+	TextBody string `json:"textBody"`
+	HtmlBody string `json:"htmlBody"`
+
+	ReadAt    *time.Time `json:"readAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type inboxTransport struct {
+	repo InboxRepository
+}
+
+// NewInboxTransport creates a transport that writes jobs to the inbox repository
+func NewInboxTransport(repo InboxRepository) Transport {
+	return &inboxTransport{
+		repo: repo,
+	}
+}
+
+func (t *inboxTransport) Send(ctx context.Context, job *Job, template Template, render RenderFunc) error {
+	subject, err := render(FieldSubject, job.Params)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to render subject for job %s template %s", job.Uuid, template.TemplateId)
+	}
+
+	textBody, err := render(FieldTextBody, job.Params)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to render text body for job %s template %s", job.Uuid, template.TemplateId)
+	}
+
+	htmlBody, err := render(FieldHtmlBody, job.Params)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to render html body for job %s template %s", job.Uuid, template.TemplateId)
+	}
+
+	// Reuse the job uuid so a retried job does not end up twice in the inbox
+	message := &InboxMessage{
+		Uuid:       job.Uuid,
+		Recipient:  job.Target,
+		ExternalId: job.ExternalId,
+		TemplateId: template.TemplateId,
+		Locale:     template.Locale,
+		Subject:    subject,
+		TextBody:   textBody,
+		HtmlBody:   htmlBody,
+		CreatedAt:  time.Now(),
+	}
+
+	// A retried job finding its message already stored has nothing left to do. Deleted messages are
+	// removed from the repository, so a job retried after its message was deleted stores it again
+	if err := t.repo.Create(message); err != nil && err != InboxMessageExistsErr {
+		return errors.Wrap(err, "Failed to store inbox message")
+	}
+
+	return nil
+}
@@ -1,19 +1,49 @@
 package internal
 
+import "encoding/json"
+
 type TestTemplateRequest struct {
 	Id     string `json:"id"`
 	Type   string `json:"type"`
 	Target string `json:"target"`
+	Draft  bool   `json:"draft"`
 }
 
 type UpdateTemplateRequest struct {
-	UpdateParameters bool   `json:"updateParameters"`
-	Enabled          bool   `json:"enabled"`
-	Description      string `json:"description"`
+	UpdateParameters bool            `json:"updateParameters"`
+	Enabled          bool            `json:"enabled"`
+	Description      string          `json:"description"`
+	Schema           json.RawMessage `json:"schema"`
+
+	Layout     string `json:"layout"`
+	BodyFormat string `json:"bodyFormat"`
+	Subject    string `json:"subject"`
+	HtmlBody   string `json:"htmlBody"`
+	TextBody   string `json:"textBody"`
+
+	FromName    string `json:"fromName"`
+	FromAddress string `json:"fromAddress"`
+	ReplyTo     string `json:"replyTo"`
+	SmsSender   string `json:"smsSender"`
+
+	SmsMaxSegments int    `json:"smsMaxSegments"`
+	SmsLimitAction string `json:"smsLimitAction"`
+}
+
+type CreateTemplateRequest struct {
+	Id     string `json:"id"`
+	Locale string `json:"locale"`
+	Kind   string `json:"kind"`
+
+	UpdateTemplateRequest
+}
+
+type PreviewTemplateRequest struct {
+	Parameters map[string]interface{} `json:"parameters"`
+	Draft      bool                   `json:"draft"`
 
-	Subject  string `json:"subject"`
-	HtmlBody string `json:"htmlBody"`
-	TextBody string `json:"textBody"`
+	// Template previews unsaved content instead of the stored template
+	Template *UpdateTemplateRequest `json:"template"`
 }
 
 type ResubscribeRequest struct {
@@ -1,9 +1,14 @@
 package communication
 
 import (
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 )
 
 type JobType string
@@ -11,6 +16,7 @@ type JobType string
 const (
 	JobSms   JobType = "sms"
 	JobEmail JobType = "email"
+	JobInbox JobType = "inbox"
 )
 
 type Job struct {
@@ -22,8 +28,215 @@ type Job struct {
 	Locale     string `json:"locale"`
 	Target     string `json:"target"`
 
+	// ResolvedLocale and TemplateVersion identify the template the job was rendered with
+	ResolvedLocale  string `json:"resolvedLocale"`
+	TemplateVersion int    `sql:",notnull" json:"templateVersion"`
+
+	// Draft renders the job using the draft of the template, only used for test sends
+	Draft bool `sql:",notnull" json:"draft"`
+
+	// Additional recipients of email jobs, Target is always the first to recipient
+	To  []string `sql:"to_addresses,array" json:"to"`
+	Cc  []string `sql:"cc_addresses,array" json:"cc"`
+	Bcc []string `sql:"bcc_addresses,array" json:"bcc"`
+
 	Params map[string]interface{} `json:"params"`
 
-	SentAt    *time.Time `json:"sentAt"`
-	CreatedAt time.Time  `json:"createdAt"`
+	Attachments []Attachment `json:"attachments"`
+
+	// Headers are added to email jobs, metadata and tags are passed on to providers supporting them
+	Headers  map[string]string `json:"headers"`
+	Metadata map[string]string `json:"metadata"`
+	Tags     []string          `sql:",array" json:"tags"`
+
+	// Provider is the name of the provider that sent the job when using the failover transport
+	Provider string `json:"provider"`
+
+	ChainUuid  *uuid.UUID     `json:"chainUuid"`
+	ParentUuid *uuid.UUID     `json:"parentUuid"`
+	ChainStep  int            `sql:",notnull" json:"chainStep"`
+	Chain      []FallbackStep `json:"chain"`
+
+	Error string `json:"error"`
+
+	// EscalateAt is when the job escalates to the next step of its chain unless the delivery is confirmed
+	EscalateAt *time.Time `json:"escalateAt"`
+
+	// HeldAt is set while the job waits for its template to be enabled
+	HeldAt *time.Time `json:"heldAt"`
+
+	SentAt      *time.Time `json:"sentAt"`
+	FailedAt    *time.Time `json:"failedAt"`
+	DeliveredAt *time.Time `json:"deliveredAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+
+	// template is resolved when validating the parameters of a new job and reused when sending it
+	template *Template
+}
+
+// Recipients returns the target together with the additional to recipients
+func (job *Job) Recipients() []string {
+	return append([]string{job.Target}, job.To...)
+}
+
+func (job *Job) validateRecipients() error {
+	if job.Type != JobEmail {
+		if len(job.To) > 0 || len(job.Cc) > 0 || len(job.Bcc) > 0 {
+			return errors.Errorf("Additional recipients are not supported for job type %s", job.Type)
+		}
+
+		return nil
+	}
+
+	for _, list := range [][]string{job.Recipients(), job.Cc, job.Bcc} {
+		for _, address := range list {
+			if _, err := mail.ParseAddress(address); err != nil {
+				return errors.Wrapf(err, "Invalid email address %s", address)
+			}
+		}
+	}
+
+	return nil
+}
+
+// reservedHeaders are set by the transports and may not be overridden per job
+var reservedHeaders = map[string]bool{
+	"From":                      true,
+	"To":                        true,
+	"Cc":                        true,
+	"Bcc":                       true,
+	"Reply-To":                  true,
+	"Subject":                   true,
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+}
+
+func (job *Job) validateHeaders() error {
+	if len(job.Headers) > 0 && job.Type != JobEmail {
+		return errors.Errorf("Headers are not supported for job type %s", job.Type)
+	}
+
+	for name, value := range job.Headers {
+		if name == "" || strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r >= 127 || r == ':' }) >= 0 {
+			return errors.Errorf("Invalid header name %q", name)
+		}
+
+		if reservedHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+			return errors.Errorf("Header %s can not be overridden", name)
+		}
+
+		if strings.ContainsAny(value, "\r\n") {
+			return errors.Errorf("Invalid value for header %s", name)
+		}
+	}
+
+	return nil
+}
+
+// SanitizeTag replaces characters not allowed in provider tag names and values, e.g. ses message tags
+func SanitizeTag(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+
+		return '_'
+	}, value)
+
+	if len(value) > 256 {
+		value = value[:256]
+	}
+
+	return value
+}
+
+// validateMetadata rejects tags and metadata keys ending up with the same tag name once sanitized
+func (job *Job) validateMetadata() error {
+	names := map[string]string{"template": "the template id"}
+
+	for _, tag := range job.Tags {
+		if tag == "" {
+			return errors.New("Tags can not be empty")
+		}
+
+		name := SanitizeTag(tag)
+		if other, ok := names[name]; ok {
+			return errors.Errorf("Tag %s collides with %s", tag, other)
+		}
+
+		names[name] = "tag " + tag
+	}
+
+	keys := make([]string, 0, len(job.Metadata))
+	for key := range job.Metadata {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key == "" {
+			return errors.New("Metadata keys can not be empty")
+		}
+
+		name := SanitizeTag(key)
+		if other, ok := names[name]; ok {
+			return errors.Errorf("Metadata key %s collides with %s", key, other)
+		}
+
+		names[name] = "metadata key " + key
+	}
+
+	return nil
+}
+
+// WithHeader adds a custom header to an email
+func WithHeader(name, value string) SendOption {
+	return func(job *Job) {
+		if job.Headers == nil {
+			job.Headers = map[string]string{}
+		}
+
+		job.Headers[name] = value
+	}
+}
+
+// WithMetadata adds key/value metadata passed on to the provider, e.g. mailgun variables or ses message tags
+func WithMetadata(key, value string) SendOption {
+	return func(job *Job) {
+		if job.Metadata == nil {
+			job.Metadata = map[string]string{}
+		}
+
+		job.Metadata[key] = value
+	}
+}
+
+// WithTags adds provider tags in addition to the template id tag
+func WithTags(tags ...string) SendOption {
+	return func(job *Job) {
+		job.Tags = append(job.Tags, tags...)
+	}
+}
+
+// WithTo adds recipients to an email
+func WithTo(addresses ...string) SendOption {
+	return func(job *Job) {
+		job.To = append(job.To, addresses...)
+	}
+}
+
+// WithCc adds carbon copy recipients to an email
+func WithCc(addresses ...string) SendOption {
+	return func(job *Job) {
+		job.Cc = append(job.Cc, addresses...)
+	}
+}
+
+// WithBcc adds blind carbon copy recipients to an email
+func WithBcc(addresses ...string) SendOption {
+	return func(job *Job) {
+		job.Bcc = append(job.Bcc, addresses...)
+	}
 }
@@ -0,0 +1,273 @@
+package communication
+
+import (
+	htmltemplate "html/template"
+	texttemplate "text/template"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateKind separates content templates from the layouts and partials they share
+type TemplateKind string
+
+const (
+	TemplateKindContent TemplateKind = "content"
+	TemplateKindLayout  TemplateKind = "layout"
+	TemplateKindPartial TemplateKind = "partial"
+)
+
+// layoutContent is the name layouts use to include the content, e.g. {{ template "content" . }}
+const layoutContent = "content"
+
+// templateSet abstracts over text and html templates while resolving layouts and partials
+type templateSet interface {
+	define(name, body string) error
+	trees() []*parse.Tree
+	defined(name string) bool
+}
+
+type textSet struct {
+	root *texttemplate.Template
+}
+
+func (s *textSet) define(name, body string) error {
+	if name == s.root.Name() {
+		_, err := s.root.Parse(body)
+		return err
+	}
+
+	_, err := s.root.New(name).Parse(body)
+	return err
+}
+
+func (s *textSet) trees() []*parse.Tree {
+	var trees []*parse.Tree
+	for _, t := range s.root.Templates() {
+		trees = append(trees, t.Tree)
+	}
+
+	return trees
+}
+
+func (s *textSet) defined(name string) bool {
+	t := s.root.Lookup(name)
+	return t != nil && t.Tree != nil
+}
+
+type htmlSet struct {
+	root *htmltemplate.Template
+}
+
+func (s *htmlSet) define(name, body string) error {
+	if name == s.root.Name() {
+		_, err := s.root.Parse(body)
+		return err
+	}
+
+	_, err := s.root.New(name).Parse(body)
+	return err
+}
+
+func (s *htmlSet) trees() []*parse.Tree {
+	var trees []*parse.Tree
+	for _, t := range s.root.Templates() {
+		trees = append(trees, t.Tree)
+	}
+
+	return trees
+}
+
+func (s *htmlSet) defined(name string) bool {
+	t := s.root.Lookup(name)
+	return t != nil && t.Tree != nil
+}
+
+// assemble parses the body of a template into the set, wrapping it in its layout and
+// resolving the partials it includes by name from the template repository
+func (a *application) assemble(set templateSet, rootName string, template Template, body func(Template) (string, error)) ([]templateKey, error) {
+	var dependencies []templateKey
+
+	switch {
+	case template.Kind == TemplateKindLayout:
+		// Layouts are validated on their own using an empty content placeholder
+		if err := defineBody(set, rootName, template, body); err != nil {
+			return nil, err
+		}
+
+		if err := set.define(layoutContent, ""); err != nil {
+			return nil, err
+		}
+
+	case template.Layout != "":
+		layout, err := a.lookupShared(template.Layout, template.Locale, TemplateKindLayout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to retrieve layout %s", template.Layout)
+		}
+
+		dependencies = append(dependencies, templateKey{layout.TemplateId, layout.Locale})
+
+		layoutBody, err := body(layout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to compile layout %s", template.Layout)
+		}
+
+		// Layouts without a body for this field render the content as is
+		if layoutBody == "" {
+			if err := defineBody(set, rootName, template, body); err != nil {
+				return nil, err
+			}
+
+			break
+		}
+
+		if err := set.define(rootName, layoutBody); err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse layout %s", template.Layout)
+		}
+
+		if err := defineBody(set, layoutContent, template, body); err != nil {
+			return nil, err
+		}
+
+	default:
+		if err := defineBody(set, rootName, template, body); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		missing := missingTemplates(set)
+		if len(missing) == 0 {
+			return dependencies, nil
+		}
+
+		for _, name := range missing {
+			partial, err := a.lookupShared(name, template.Locale, TemplateKindPartial)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to retrieve partial %s", name)
+			}
+
+			dependencies = append(dependencies, templateKey{partial.TemplateId, partial.Locale})
+
+			if err := defineBody(set, name, partial, body); err != nil {
+				return nil, errors.Wrapf(err, "Failed to parse partial %s", name)
+			}
+		}
+	}
+}
+
+func defineBody(set templateSet, name string, template Template, body func(Template) (string, error)) error {
+	content, err := body(template)
+	if err != nil {
+		return err
+	}
+
+	return set.define(name, content)
+}
+
+// lookupShared retrieves an enabled layout or partial using the same locale resolution as content templates
+func (a *application) lookupShared(templateId, locale string, kind TemplateKind) (Template, error) {
+	for _, candidate := range a.localeChain(locale) {
+		tpl, err := a.lookupTemplate(templateId, candidate)
+		switch {
+		case err == TemplateNotFoundErr || (err == nil && !tpl.Enabled):
+			continue
+
+		case err != nil:
+			return tpl, err
+
+		case tpl.Kind != kind:
+			return tpl, errors.Errorf("Template %s for locale %s is not a %s", templateId, candidate, kind)
+		}
+
+		return tpl, nil
+	}
+
+	return Template{}, TemplateNotFoundErr
+}
+
+// missingTemplates returns the names included using {{ template }} that are not yet defined
+func missingTemplates(set templateSet) []string {
+	seen := map[string]bool{}
+
+	var missing []string
+
+	for _, tree := range set.trees() {
+		if tree == nil {
+			continue
+		}
+
+		walkNodes(tree.Root, func(node parse.Node) {
+			t, ok := node.(*parse.TemplateNode)
+			if !ok || seen[t.Name] || set.defined(t.Name) {
+				return
+			}
+
+			seen[t.Name] = true
+			missing = append(missing, t.Name)
+		})
+	}
+
+	return missing
+}
+
+// walkNodes calls fn for every node in the tree
+func walkNodes(node parse.Node, fn func(parse.Node)) {
+	switch n := node.(type) {
+	case nil:
+		return
+
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+	}
+
+	fn(node)
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		for _, child := range n.Nodes {
+			walkNodes(child, fn)
+		}
+
+	case *parse.ActionNode:
+		walkNodes(n.Pipe, fn)
+
+	case *parse.PipeNode:
+		for _, cmd := range n.Cmds {
+			walkNodes(cmd, fn)
+		}
+
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkNodes(arg, fn)
+		}
+
+	case *parse.ChainNode:
+		walkNodes(n.Node, fn)
+
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, fn)
+
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, fn)
+
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, fn)
+
+	case *parse.TemplateNode:
+		walkNodes(n.Pipe, fn)
+	}
+}
+
+func walkBranch(n *parse.BranchNode, fn func(parse.Node)) {
+	walkNodes(n.Pipe, fn)
+	walkNodes(n.List, fn)
+	walkNodes(n.ElseList, fn)
+}
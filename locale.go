@@ -0,0 +1,107 @@
+package communication
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SetLocaleFallbacks configures the locales to try per language before the global fallback locale,
+// e.g. {"nb": {"no", "da"}} tries Norwegian and then Danish templates for Norwegian Bokmål
+func SetLocaleFallbacks(fallbacks map[string][]string) AppOption {
+	return func(a *application) {
+		a.localeFallbacks = fallbacks
+	}
+}
+
+// localeChain returns the locales to try for a requested locale, e.g. sv-FI resolves to
+// sv-FI, sv, the fallbacks configured for sv-FI and sv, and finally the global fallback locale
+func (a *application) localeChain(locale string) []string {
+	var chain []string
+
+	seen := map[string]bool{}
+	add := func(candidates ...string) {
+		for _, candidate := range candidates {
+			if candidate != "" && !seen[strings.ToLower(candidate)] {
+				seen[strings.ToLower(candidate)] = true
+				chain = append(chain, candidate)
+			}
+		}
+	}
+
+	parents := localeParents(locale)
+	add(parents...)
+
+	for _, parent := range parents {
+		for _, fallback := range a.localeFallbacks[parent] {
+			add(localeParents(fallback)...)
+		}
+	}
+
+	add(a.fallbackLocale)
+
+	return chain
+}
+
+// localeParents returns the locale followed by its parents, e.g. zh-Hant-TW, zh-Hant, zh
+func localeParents(locale string) []string {
+	var parents []string
+
+	for locale != "" {
+		parents = append(parents, locale)
+
+		i := strings.LastIndexAny(locale, "-_")
+		if i < 0 {
+			break
+		}
+
+		locale = locale[:i]
+	}
+
+	return parents
+}
+
+// resolveTemplate returns the first enabled template in the locale chain used for jobs
+func (a *application) resolveTemplate(templateId, locale string) (Template, error) {
+	return a.resolveTemplateIn(templateId, locale, a.jobLocaleChain(locale))
+}
+
+// jobLocaleChain returns the locales jobs are sent in, the fail policy only allows the requested language
+func (a *application) jobLocaleChain(locale string) []string {
+	if a.missingTemplatePolicy == MissingTemplateFail {
+		return localeParents(locale)
+	}
+
+	return a.localeChain(locale)
+}
+
+// resolveJobTemplate returns the template a new job will be sent with, or nil when it is created on demand
+func (a *application) resolveJobTemplate(templateId, locale string) (*Template, error) {
+	tpl, err := a.resolveTemplate(templateId, locale)
+	switch {
+	case errors.Cause(err) == TemplateNotFoundErr:
+		return nil, nil
+
+	case err != nil:
+		return nil, err
+	}
+
+	return &tpl, nil
+}
+
+func (a *application) resolveTemplateIn(templateId, locale string, chain []string) (Template, error) {
+	for _, candidate := range chain {
+		tpl, err := a.lookupTemplate(templateId, candidate)
+		switch {
+		case err == TemplateNotFoundErr || (err == nil && !tpl.Enabled):
+			continue
+
+		case err != nil:
+			return tpl, err
+		}
+
+		return tpl, nil
+	}
+
+	return Template{}, errors.Wrapf(TemplateNotFoundErr, "No enabled template %s for locale %s", templateId, locale)
+}
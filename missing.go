@@ -0,0 +1,96 @@
+package communication
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MissingTemplatePolicy decides what happens to a job when no enabled template exists for it
+type MissingTemplatePolicy string
+
+const (
+	// MissingTemplateSend creates a placeholder template and sends it, this is the default
+	MissingTemplateSend MissingTemplatePolicy = "send"
+	// MissingTemplateHold creates a placeholder template and holds the job until the template is enabled
+	MissingTemplateHold MissingTemplatePolicy = "hold"
+	// MissingTemplateFail fails the job unless the requested language has an enabled template
+	MissingTemplateFail MissingTemplatePolicy = "fail"
+	// MissingTemplateFallbackOnly resolves the full locale chain and fails the job when nothing is enabled
+	MissingTemplateFallbackOnly MissingTemplatePolicy = "fallback-only"
+)
+
+var jobHeldErr = errors.New("Job held until the template is enabled")
+
+// SetMissingTemplatePolicy configures how jobs for missing or disabled templates are handled
+func SetMissingTemplatePolicy(policy MissingTemplatePolicy) AppOption {
+	return func(a *application) {
+		a.missingTemplatePolicy = policy
+	}
+}
+
+func (a *application) createsPlaceholders() bool {
+	return a.missingTemplatePolicy == MissingTemplateSend || a.missingTemplatePolicy == MissingTemplateHold
+}
+
+func (a *application) missingTemplate(templateId, locale string) (Template, error) {
+	switch a.missingTemplatePolicy {
+	case MissingTemplateHold:
+		return Template{}, jobHeldErr
+
+	case MissingTemplateFail, MissingTemplateFallbackOnly:
+		return Template{}, errors.Wrapf(TemplateNotFoundErr, "No enabled template %s for locale %s", templateId, locale)
+	}
+
+	return a.getFallbackTemplate(templateId)
+}
+
+func (a *application) holdJob(job *Job) {
+	now := time.Now()
+
+	job.HeldAt = &now
+
+	// Record the parameters on the placeholder so the template can be written against them
+	if tpl, err := a.lookupTemplate(job.TemplateId, job.Locale); err == nil {
+		if err := a.recordParameters(tpl, job.Params); err != nil {
+			a.logger.
+				WithField("job", job).
+				WithError(err).
+				Error("failed to record parameters on placeholder template")
+		}
+	}
+
+	if err := a.jobRepo.Update(job); err != nil {
+		a.logger.
+			WithField("job", job).
+			WithError(err).
+			Error("failed to update held job in transaction repo")
+	}
+}
+
+// releaseHeldJobs queues the jobs held for a template again, jobs that still have no
+// enabled template for their locale are held again when processed
+func (a *application) releaseHeldJobs(templateId string) error {
+	jobs, _, err := a.jobRepo.Matching(JobCriteria{TemplateId: templateId, Held: true})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to find jobs held for template %s", templateId)
+	}
+
+	for i := range jobs {
+		job := jobs[i]
+
+		if job.TemplateId != templateId || job.HeldAt == nil {
+			continue
+		}
+
+		job.HeldAt = nil
+
+		if err := a.jobRepo.Update(&job); err != nil {
+			return errors.Wrapf(err, "Failed to release job %s", job.Uuid)
+		}
+
+		a.queue(&job)
+	}
+
+	return nil
+}
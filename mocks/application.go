@@ -5,12 +5,27 @@ package communication_mocks
 import communication "github.com/interactive-solutions/go-communication"
 import context "context"
 import mock "github.com/stretchr/testify/mock"
+import uuid "github.com/google/uuid"
 
 // Application is an autogenerated mock type for the Application type
 type Application struct {
 	mock.Mock
 }
 
+// ConfirmDelivery provides a mock function with given fields: jobUuid
+func (_m *Application) ConfirmDelivery(jobUuid uuid.UUID) error {
+	ret := _m.Called(jobUuid)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uuid.UUID) error); ok {
+		r0 = rf(jobUuid)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // HttpHandler provides a mock function with given fields:
 func (_m *Application) HttpHandler() *communication.HttpHandler {
 	ret := _m.Called()
@@ -27,13 +42,64 @@ func (_m *Application) HttpHandler() *communication.HttpHandler {
 	return r0
 }
 
-// SendEmail provides a mock function with given fields: id, locale, email, externalId, params
-func (_m *Application) SendEmail(id string, locale string, email string, externalId string, params map[string]interface{}) error {
-	ret := _m.Called(id, locale, email, externalId, params)
+// ImportTemplates provides a mock function with given fields: bundle, dryRun, author
+func (_m *Application) ImportTemplates(bundle communication.TemplateBundle, dryRun bool, author string) ([]communication.TemplateChange, error) {
+	ret := _m.Called(bundle, dryRun, author)
+
+	var r0 []communication.TemplateChange
+	if rf, ok := ret.Get(0).(func(communication.TemplateBundle, bool, string) []communication.TemplateChange); ok {
+		r0 = rf(bundle, dryRun, author)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]communication.TemplateChange)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(communication.TemplateBundle, bool, string) error); ok {
+		r1 = rf(bundle, dryRun, author)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Render provides a mock function with given fields: id, locale, parameters
+func (_m *Application) Render(id string, locale string, parameters map[string]interface{}) (communication.RenderedTemplate, error) {
+	ret := _m.Called(id, locale, parameters)
+
+	var r0 communication.RenderedTemplate
+	if rf, ok := ret.Get(0).(func(string, string, map[string]interface{}) communication.RenderedTemplate); ok {
+		r0 = rf(id, locale, parameters)
+	} else {
+		r0 = ret.Get(0).(communication.RenderedTemplate)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, map[string]interface{}) error); ok {
+		r1 = rf(id, locale, parameters)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SendEmail provides a mock function with given fields: id, locale, email, externalId, params, options
+func (_m *Application) SendEmail(id string, locale string, email string, externalId string, params map[string]interface{}, options ...communication.SendOption) error {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, id, locale, email, externalId, params)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string, string, string, map[string]interface{}) error); ok {
-		r0 = rf(id, locale, email, externalId, params)
+	if rf, ok := ret.Get(0).(func(string, string, string, string, map[string]interface{}, ...communication.SendOption) error); ok {
+		r0 = rf(id, locale, email, externalId, params, options...)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -41,13 +107,62 @@ func (_m *Application) SendEmail(id string, locale string, email string, externa
 	return r0
 }
 
-// SendSms provides a mock function with given fields: id, locale, number, externalId, params
-func (_m *Application) SendSms(id string, locale string, number string, externalId string, params map[string]interface{}) error {
-	ret := _m.Called(id, locale, number, externalId, params)
+// SendInbox provides a mock function with given fields: id, locale, recipient, externalId, params, options
+func (_m *Application) SendInbox(id string, locale string, recipient string, externalId string, params map[string]interface{}, options ...communication.SendOption) error {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, id, locale, recipient, externalId, params)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string, string, string, map[string]interface{}) error); ok {
-		r0 = rf(id, locale, number, externalId, params)
+	if rf, ok := ret.Get(0).(func(string, string, string, string, map[string]interface{}, ...communication.SendOption) error); ok {
+		r0 = rf(id, locale, recipient, externalId, params, options...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SendSms provides a mock function with given fields: id, locale, number, externalId, params, options
+func (_m *Application) SendSms(id string, locale string, number string, externalId string, params map[string]interface{}, options ...communication.SendOption) error {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, id, locale, number, externalId, params)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, string, string, map[string]interface{}, ...communication.SendOption) error); ok {
+		r0 = rf(id, locale, number, externalId, params, options...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SendWithFallback provides a mock function with given fields: id, locale, externalId, params, steps, options
+func (_m *Application) SendWithFallback(id string, locale string, externalId string, params map[string]interface{}, steps []communication.FallbackStep, options ...communication.SendOption) error {
+	_va := make([]interface{}, len(options))
+	for _i := range options {
+		_va[_i] = options[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, id, locale, externalId, params, steps)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, string, map[string]interface{}, []communication.FallbackStep, ...communication.SendOption) error); ok {
+		r0 = rf(id, locale, externalId, params, steps, options...)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -59,3 +174,30 @@ func (_m *Application) SendSms(id string, locale string, number string, external
 func (_m *Application) Shutdown(ctx context.Context) {
 	_m.Called(ctx)
 }
+
+// TemplateHealth provides a mock function with given fields: locales
+func (_m *Application) TemplateHealth(locales ...string) (communication.TemplateHealthReport, error) {
+	_va := make([]interface{}, len(locales))
+	for _i := range locales {
+		_va[_i] = locales[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 communication.TemplateHealthReport
+	if rf, ok := ret.Get(0).(func(...string) communication.TemplateHealthReport); ok {
+		r0 = rf(locales...)
+	} else {
+		r0 = ret.Get(0).(communication.TemplateHealthReport)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(...string) error); ok {
+		r1 = rf(locales...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -35,13 +35,13 @@ func New46ElksClient(from, username, password string) communication.Transport {
 }
 
 func (e *elks) Send(ctx context.Context, job *communication.Job, template communication.Template, render communication.RenderFunc) error {
-	message, err := render(template.TextBody, job.Params)
+	message, err := render(communication.FieldTextBody, job.Params)
 	if err != nil {
 		return errors.Wrap(err, "Failed to generate sms message from template")
 	}
 
 	body := url.Values{
-		"from":    {e.from},
+		"from":    {template.SmsFrom(e.from)},
 		"to":      {job.Target},
 		"message": {message},
 	}.Encode()
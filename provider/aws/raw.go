@@ -0,0 +1,208 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type rawAttachment struct {
+	name        string
+	contentType string
+	inline      bool
+	content     []byte
+}
+
+// rawMessage builds the MIME message used with SendRawEmail
+type rawMessage struct {
+	from    string
+	replyTo string
+	to      []string
+	cc      []string
+	subject string
+	headers map[string]string
+
+	text string
+	html string
+
+	attachments []rawAttachment
+}
+
+type mimePart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func (m *rawMessage) Bytes() ([]byte, error) {
+	contentType, body, err := multipartBody("alternative", []mimePart{
+		textPart("text/plain", m.text),
+		textPart("text/html", m.html),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var inline, attached []mimePart
+
+	for _, attachment := range m.attachments {
+		part, err := attachmentPart(attachment)
+		if err != nil {
+			return nil, err
+		}
+
+		if attachment.inline {
+			inline = append(inline, part)
+		} else {
+			attached = append(attached, part)
+		}
+	}
+
+	if len(inline) > 0 {
+		parts := append([]mimePart{{header: contentTypeHeader(contentType), body: body}}, inline...)
+
+		if contentType, body, err = multipartBody("related", parts); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(attached) > 0 {
+		parts := append([]mimePart{{header: contentTypeHeader(contentType), body: body}}, attached...)
+
+		if contentType, body, err = multipartBody("mixed", parts); err != nil {
+			return nil, err
+		}
+	}
+
+	out := &bytes.Buffer{}
+
+	writeHeader(out, "From", m.from)
+	writeHeader(out, "To", strings.Join(m.to, ", "))
+	writeHeader(out, "Cc", strings.Join(m.cc, ", "))
+	writeHeader(out, "Reply-To", m.replyTo)
+	writeHeader(out, "Subject", mime.QEncoding.Encode("UTF-8", m.subject))
+	writeHeader(out, "MIME-Version", "1.0")
+
+	// Sort custom headers to keep the output stable
+	keys := make([]string, 0, len(m.headers))
+	for key := range m.headers {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		writeHeader(out, key, mime.QEncoding.Encode("UTF-8", m.headers[key]))
+	}
+
+	writeHeader(out, "Content-Type", contentType)
+	out.WriteString("\r\n")
+	out.Write(body)
+
+	return out.Bytes(), nil
+}
+
+func writeHeader(out *bytes.Buffer, key, value string) {
+	if value == "" {
+		return
+	}
+
+	out.WriteString(key + ": " + value + "\r\n")
+}
+
+func contentTypeHeader(contentType string) textproto.MIMEHeader {
+	return textproto.MIMEHeader{"Content-Type": {contentType}}
+}
+
+func textPart(contentType, content string) mimePart {
+	body := &bytes.Buffer{}
+
+	w := quotedprintable.NewWriter(body)
+	w.Write([]byte(content))
+	w.Close()
+
+	return mimePart{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {contentType + "; charset=UTF-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		body: body.Bytes(),
+	}
+}
+
+func attachmentPart(attachment rawAttachment) (mimePart, error) {
+	if strings.ContainsAny(attachment.name, "\r\n") {
+		return mimePart{}, errors.Errorf("Invalid attachment name %q", attachment.name)
+	}
+
+	contentType := attachment.contentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	disposition := "attachment"
+	if attachment.inline {
+		disposition = "inline"
+	}
+
+	// FormatMediaType returns an empty string for invalid media types
+	contentType = mime.FormatMediaType(contentType, map[string]string{"name": attachment.name})
+	if contentType == "" {
+		return mimePart{}, errors.Errorf("Invalid content type %q for attachment %s", attachment.contentType, attachment.name)
+	}
+
+	header := textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Disposition":       {mime.FormatMediaType(disposition, map[string]string{"filename": attachment.name})},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+
+	if attachment.inline {
+		if strings.ContainsAny(attachment.name, "<>") {
+			return mimePart{}, errors.Errorf("Inline attachment name %q can not be used as a content id", attachment.name)
+		}
+
+		header.Set("Content-ID", "<"+attachment.name+">")
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(attachment.content)
+	body := &bytes.Buffer{}
+
+	// Wrap the base64 encoded content at 76 characters per line
+	for len(encoded) > 76 {
+		body.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+
+	body.WriteString(encoded)
+
+	return mimePart{header: header, body: body.Bytes()}, nil
+}
+
+func multipartBody(subtype string, parts []mimePart) (string, []byte, error) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+
+	for _, part := range parts {
+		pw, err := w.CreatePart(part.header)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if _, err := pw.Write(part.body); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return "", nil, err
+	}
+
+	return "multipart/" + subtype + "; boundary=" + w.Boundary(), body.Bytes(), nil
+}
@@ -0,0 +1,138 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawMessage(t *testing.T) {
+	msg := &rawMessage{
+		from:    "Acme <noreply@acme.com>",
+		replyTo: "support@acme.com",
+		to:      []string{"anna@example.com", "erik@example.com"},
+		cc:      []string{"lisa@example.com"},
+		subject: "Välkommen",
+		headers: map[string]string{"X-Campaign": "spring"},
+		text:    "Hello",
+		html:    `<p>Hello <img src="cid:logo.png"></p>`,
+		attachments: []rawAttachment{
+			{name: "logo.png", contentType: "image/png", inline: true, content: []byte("png")},
+			{name: "invoice.pdf", contentType: "application/pdf", content: bytes.Repeat([]byte("pdf"), 100)},
+		},
+	}
+
+	data, err := msg.Bytes()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "anna@example.com, erik@example.com", parsed.Header.Get("To"))
+	assert.Equal(t, "lisa@example.com", parsed.Header.Get("Cc"))
+	assert.Equal(t, "support@acme.com", parsed.Header.Get("Reply-To"))
+	assert.Equal(t, "spring", parsed.Header.Get("X-Campaign"))
+	assert.Empty(t, parsed.Header.Get("Bcc"))
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Välkommen", subject)
+
+	// mixed(related(alternative(text, html), logo), invoice)
+	mixed := readParts(t, parsed.Header.Get("Content-Type"), parsed.Body)
+	if !assert.Len(t, mixed, 2) {
+		return
+	}
+
+	assert.Equal(t, `attachment; filename=invoice.pdf`, mixed[1].header.Get("Content-Disposition"))
+	assert.Equal(t, "application/pdf; name=invoice.pdf", mixed[1].header.Get("Content-Type"))
+	assert.Equal(t, bytes.Repeat([]byte("pdf"), 100), mixed[1].body)
+
+	related := readParts(t, mixed[0].header.Get("Content-Type"), bytes.NewReader(mixed[0].body))
+	if !assert.Len(t, related, 2) {
+		return
+	}
+
+	assert.Equal(t, "<logo.png>", related[1].header.Get("Content-ID"))
+	assert.Equal(t, "inline; filename=logo.png", related[1].header.Get("Content-Disposition"))
+	assert.Equal(t, []byte("png"), related[1].body)
+
+	alternative := readParts(t, related[0].header.Get("Content-Type"), bytes.NewReader(related[0].body))
+	if assert.Len(t, alternative, 2) {
+		assert.Equal(t, "Hello", string(alternative[0].body))
+		assert.Equal(t, `<p>Hello <img src="cid:logo.png"></p>`, string(alternative[1].body))
+	}
+}
+
+func TestRawMessageWithoutAttachments(t *testing.T) {
+	data, err := (&rawMessage{from: "noreply@acme.com", to: []string{"anna@example.com"}, text: "Hello", html: "<p>Hello</p>"}).Bytes()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, strings.HasPrefix(parsed.Header.Get("Content-Type"), "multipart/alternative"))
+	assert.Empty(t, parsed.Header.Get("Cc"))
+	assert.Empty(t, parsed.Header.Get("Reply-To"))
+}
+
+func TestRawMessageRejectsInvalidAttachments(t *testing.T) {
+	for _, attachment := range []rawAttachment{
+		{name: "invoice.pdf\r\nBcc: eve@example.com"},
+		{name: "invoice.pdf", contentType: "not a type"},
+		{name: "<logo>", inline: true},
+	} {
+		_, err := (&rawMessage{attachments: []rawAttachment{attachment}}).Bytes()
+		assert.Error(t, err, attachment.name)
+	}
+}
+
+type decodedPart struct {
+	header mail.Header
+	body   []byte
+}
+
+func readParts(t *testing.T, contentType string, body io.Reader) []decodedPart {
+	_, params, err := mime.ParseMediaType(contentType)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+
+	var parts []decodedPart
+
+	reader := multipart.NewReader(body, params["boundary"])
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+
+		// NextPart decodes quoted-printable parts, base64 attachments are decoded here
+		content, _ := ioutil.ReadAll(part)
+		if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+			content, err = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(content), "\r\n", ""))
+			assert.NoError(t, err)
+		}
+
+		parts = append(parts, decodedPart{header: mail.Header(part.Header), body: content})
+	}
+
+	return parts
+}
@@ -2,6 +2,8 @@ package provider
 
 import (
 	"context"
+	"sort"
+
 	"github.com/interactive-solutions/go-communication"
 	"github.com/pkg/errors"
 
@@ -26,35 +28,34 @@ func NewSesTransport(sess *session.Session, from string) communication.Transport
 }
 
 func (transport *sesTransport) Send(ctx context.Context, job *communication.Job, template communication.Template, render communication.RenderFunc) error {
-	subject, err := render(template.Subject, job.Params)
+	subject, err := render(communication.FieldSubject, job.Params)
 	if err != nil {
 		return errors.Wrapf(err, "Failed to render subject for job %s template %s", job.Uuid, template.TemplateId)
 	}
 
-	textBody, err := render(template.TextBody, job.Params)
+	textBody, err := render(communication.FieldTextBody, job.Params)
 	if err != nil {
 		return errors.Wrapf(err, "Failed to render text body for job %s template %s", job.Uuid, template.TemplateId)
 	}
 
-	htmlBody, err := render(template.HtmlBody, job.Params)
+	htmlBody, err := render(communication.FieldHtmlBody, job.Params)
 	if err != nil {
 		return errors.Wrapf(err, "Failed to render html body for job %s template %s", job.Uuid, template.TemplateId)
 	}
 
+	// Attachments and custom headers are only supported when sending raw emails
+	if len(job.Attachments) > 0 || len(job.Headers) > 0 {
+		return transport.sendRaw(ctx, job, template, subject, textBody, htmlBody)
+	}
+
 	// Assemble the email.
 	input := &ses.SendEmailInput{
 		Destination: &ses.Destination{
-			CcAddresses: []*string{},
-			ToAddresses: []*string{
-				aws.String(job.Target),
-			},
-		},
-		Tags: []*ses.MessageTag{
-			{
-				Name:  aws.String("template"),
-				Value: aws.String(template.TemplateId),
-			},
+			ToAddresses:  aws.StringSlice(job.Recipients()),
+			CcAddresses:  aws.StringSlice(job.Cc),
+			BccAddresses: aws.StringSlice(job.Bcc),
 		},
+		Tags: messageTags(job, template),
 		Message: &ses.Message{
 			Body: &ses.Body{
 				Html: &ses.Content{
@@ -72,10 +73,93 @@ func (transport *sesTransport) Send(ctx context.Context, job *communication.Job,
 			},
 		},
 
-		Source: aws.String(transport.from),
+		Source: aws.String(template.EmailFrom(transport.from)),
+	}
+
+	if template.ReplyTo != "" {
+		input.ReplyToAddresses = []*string{aws.String(template.ReplyTo)}
 	}
 
 	// Attempt to send the email.
 	_, err = transport.ses.SendEmail(input)
 	return errors.Wrap(err, "Failed to send email")
 }
+
+// sendRaw sends the email as a raw MIME message, required for attachments
+func (transport *sesTransport) sendRaw(ctx context.Context, job *communication.Job, template communication.Template, subject, textBody, htmlBody string) error {
+	msg := &rawMessage{
+		from:    template.EmailFrom(transport.from),
+		replyTo: template.ReplyTo,
+		to:      job.Recipients(),
+		cc:      job.Cc,
+		subject: subject,
+		headers: job.Headers,
+		text:    textBody,
+		html:    htmlBody,
+	}
+
+	for _, attachment := range job.Attachments {
+		content, err := attachment.Load(ctx)
+		if err != nil {
+			return err
+		}
+
+		msg.attachments = append(msg.attachments, rawAttachment{
+			name:        attachment.Name,
+			contentType: attachment.ContentType,
+			inline:      attachment.Inline,
+			content:     content,
+		})
+	}
+
+	data, err := msg.Bytes()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to build raw email for job %s", job.Uuid)
+	}
+
+	input := &ses.SendRawEmailInput{
+		// Bcc recipients are only part of the destinations and never of the message headers
+		Destinations: aws.StringSlice(append(append(job.Recipients(), job.Cc...), job.Bcc...)),
+		Tags:         messageTags(job, template),
+		RawMessage: &ses.RawMessage{
+			Data: data,
+		},
+		Source: aws.String(msg.from),
+	}
+
+	_, err = transport.ses.SendRawEmailWithContext(ctx, input)
+	return errors.Wrap(err, "Failed to send raw email")
+}
+
+// messageTags converts the template id, job tags and metadata to ses message tags
+func messageTags(job *communication.Job, template communication.Template) []*ses.MessageTag {
+	tags := []*ses.MessageTag{
+		{
+			Name:  aws.String("template"),
+			Value: aws.String(communication.SanitizeTag(template.TemplateId)),
+		},
+	}
+
+	for _, tag := range job.Tags {
+		tags = append(tags, &ses.MessageTag{
+			Name:  aws.String(communication.SanitizeTag(tag)),
+			Value: aws.String("true"),
+		})
+	}
+
+	keys := make([]string, 0, len(job.Metadata))
+	for key := range job.Metadata {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		tags = append(tags, &ses.MessageTag{
+			Name:  aws.String(communication.SanitizeTag(key)),
+			Value: aws.String(communication.SanitizeTag(job.Metadata[key])),
+		})
+	}
+
+	return tags
+}
@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/interactive-solutions/go-communication"
+)
+
+func render(field communication.TemplateField, params map[string]interface{}) (string, error) {
+	return string(field), nil
+}
+
+// newTestTransport returns a ses transport sending to a local server that records the request form
+func newTestTransport(t *testing.T, form *url.Values) (communication.Transport, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		*form = r.Form
+
+		action := r.Form.Get("Action")
+
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<` + action + `Response><` + action + `Result><MessageId>1</MessageId></` + action + `Result></` + action + `Response>`))
+	}))
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("eu-west-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	assert.NoError(t, err)
+
+	return NewSesTransport(sess, "noreply@example.com"), server.Close
+}
+
+func recipientsJob() *communication.Job {
+	return &communication.Job{
+		Uuid:   uuid.New(),
+		Type:   communication.JobEmail,
+		Target: "anna@example.com",
+		To:     []string{"erik@example.com"},
+		Cc:     []string{"lisa@example.com"},
+		Bcc:    []string{"audit@example.com"},
+	}
+}
+
+func TestSendPassesRecipients(t *testing.T) {
+	var form url.Values
+
+	transport, stop := newTestTransport(t, &form)
+	defer stop()
+
+	err := transport.Send(context.Background(), recipientsJob(), communication.Template{TemplateId: "welcome"}, render)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "SendEmail", form.Get("Action"))
+	assert.Equal(t, "anna@example.com", form.Get("Destination.ToAddresses.member.1"))
+	assert.Equal(t, "erik@example.com", form.Get("Destination.ToAddresses.member.2"))
+	assert.Equal(t, "lisa@example.com", form.Get("Destination.CcAddresses.member.1"))
+	assert.Equal(t, "audit@example.com", form.Get("Destination.BccAddresses.member.1"))
+}
+
+func TestSendRawPassesRecipients(t *testing.T) {
+	var form url.Values
+
+	transport, stop := newTestTransport(t, &form)
+	defer stop()
+
+	job := recipientsJob()
+	job.Headers = map[string]string{"X-Campaign": "spring"}
+
+	err := transport.Send(context.Background(), job, communication.Template{TemplateId: "welcome"}, render)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "SendRawEmail", form.Get("Action"))
+
+	var destinations []string
+	for i := 1; form.Get("Destinations.member."+strconv.Itoa(i)) != ""; i++ {
+		destinations = append(destinations, form.Get("Destinations.member."+strconv.Itoa(i)))
+	}
+
+	assert.Equal(t, []string{"anna@example.com", "erik@example.com", "lisa@example.com", "audit@example.com"}, destinations)
+
+	data, err := base64.StdEncoding.DecodeString(form.Get("RawMessage.Data"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "anna@example.com, erik@example.com", msg.Header.Get("To"))
+	assert.Equal(t, "lisa@example.com", msg.Header.Get("Cc"))
+	assert.Empty(t, msg.Header.Get("Bcc"), "Bcc recipients should never be part of the message")
+	assert.Equal(t, "spring", msg.Header.Get("X-Campaign"))
+}
+
+func TestMessageTags(t *testing.T) {
+	job := &communication.Job{
+		Tags:     []string{"receipt", "black friday"},
+		Metadata: map[string]string{"order.id": "A-1/2", "customer": "anna@example.com"},
+	}
+
+	tags := messageTags(job, communication.Template{TemplateId: "order.confirmation"})
+
+	var pairs []string
+	for _, tag := range tags {
+		pairs = append(pairs, aws.StringValue(tag.Name)+"="+aws.StringValue(tag.Value))
+	}
+
+	assert.Equal(t, []string{
+		"template=order_confirmation",
+		"receipt=true",
+		"black_friday=true",
+		"customer=anna_example_com",
+		"order_id=A-1_2",
+	}, pairs)
+}
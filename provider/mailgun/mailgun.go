@@ -1,7 +1,10 @@
 package mailgun
 
 import (
+	"bytes"
 	"context"
+	"io/ioutil"
+
 	"github.com/mailgun/mailgun-go/v3"
 	"github.com/pkg/errors"
 
@@ -86,12 +89,12 @@ func (t *mailgunTransport) ResubscribeToTemplate(ctx context.Context, email, tem
 
 func (t *mailgunTransport) Send(ctx context.Context, job *communication.Job, template communication.Template, render communication.RenderFunc) error {
 
-	subject, err := render(template.Subject, job.Params)
+	subject, err := render(communication.FieldSubject, job.Params)
 	if err != nil {
 		return errors.Wrapf(err, "Failed to render subject for job %s template %s", job.Uuid, template.TemplateId)
 	}
 
-	htmlBody, err := render(template.HtmlBody, job.Params)
+	htmlBody, err := render(communication.FieldHtmlBody, job.Params)
 	if err != nil {
 		return errors.Wrapf(err, "Failed to render html body for job %s template %s", job.Uuid, template.TemplateId)
 	}
@@ -99,21 +102,52 @@ func (t *mailgunTransport) Send(ctx context.Context, job *communication.Job, tem
 	var textBody string
 
 	if !t.skipText {
-		textBody, err = render(template.TextBody, job.Params)
+		textBody, err = render(communication.FieldTextBody, job.Params)
 		if err != nil {
 			return errors.Wrapf(err, "Failed to render text body for job %s template %s", job.Uuid, template.TemplateId)
 		}
 	}
 
-	msg := t.mg.NewMessage(t.from, subject, textBody, job.Target)
+	msg := t.mg.NewMessage(template.EmailFrom(t.from), subject, textBody, job.Recipients()...)
 	msg.SetHtml(htmlBody)
 
-	if err := msg.AddTag(template.TemplateId); err != nil {
+	for _, cc := range job.Cc {
+		msg.AddCC(cc)
+	}
+
+	for _, bcc := range job.Bcc {
+		msg.AddBCC(bcc)
+	}
+
+	for _, attachment := range job.Attachments {
+		content, err := attachment.Load(ctx)
+		if err != nil {
+			return err
+		}
+
+		if attachment.Inline {
+			msg.AddReaderInline(attachment.Name, ioutil.NopCloser(bytes.NewReader(content)))
+		} else {
+			msg.AddBufferAttachment(attachment.Name, content)
+		}
+	}
+
+	if err := msg.AddTag(append([]string{template.TemplateId}, job.Tags...)...); err != nil {
 		return errors.Wrap(err, "Failed to add tags")
 	}
 
-	if t.replyTo != "" {
-		msg.SetReplyTo(t.replyTo)
+	for name, value := range job.Headers {
+		msg.AddHeader(name, value)
+	}
+
+	for key, value := range job.Metadata {
+		if err := msg.AddVariable(key, value); err != nil {
+			return errors.Wrapf(err, "Failed to add variable %s", key)
+		}
+	}
+
+	if replyTo := template.EmailReplyTo(t.replyTo); replyTo != "" {
+		msg.SetReplyTo(replyTo)
 	}
 
 	_, _, err = t.mg.Send(ctx, msg)
@@ -0,0 +1,62 @@
+package mailgun
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mailgun/mailgun-go/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/interactive-solutions/go-communication"
+)
+
+func render(field communication.TemplateField, params map[string]interface{}) (string, error) {
+	return string(field), nil
+}
+
+func TestSendPassesRecipients(t *testing.T) {
+	var form url.Values
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseMultipartForm(1 << 20)
+		form = r.Form
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "<1@example.com>", "message": "Queued. Thank you."}`))
+	}))
+	defer server.Close()
+
+	mg := mailgun.NewMailgun("example.com", "key")
+	mg.SetAPIBase(server.URL)
+
+	transport := NewMailgunTransport(mg, SetFrom("noreply@example.com"), SetReplyTo("support@example.com"))
+
+	job := &communication.Job{
+		Uuid:     uuid.New(),
+		Type:     communication.JobEmail,
+		Target:   "anna@example.com",
+		To:       []string{"erik@example.com"},
+		Cc:       []string{"lisa@example.com", "olle@example.com"},
+		Bcc:      []string{"audit@example.com"},
+		Headers:  map[string]string{"X-Campaign": "spring"},
+		Metadata: map[string]string{"orderId": "1"},
+		Tags:     []string{"receipt"},
+	}
+
+	err := transport.Send(context.Background(), job, communication.Template{TemplateId: "welcome"}, render)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []string{"anna@example.com", "erik@example.com"}, form["to"])
+	assert.Equal(t, []string{"lisa@example.com", "olle@example.com"}, form["cc"])
+	assert.Equal(t, []string{"audit@example.com"}, form["bcc"])
+	assert.Equal(t, []string{"welcome", "receipt"}, form["o:tag"])
+	assert.Equal(t, "spring", form.Get("h:X-Campaign"))
+	assert.Equal(t, "support@example.com", form.Get("h:Reply-To"))
+	assert.Equal(t, "noreply@example.com", form.Get("from"))
+}
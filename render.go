@@ -0,0 +1,224 @@
+package communication
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateField identifies the part of a template to render
+type TemplateField string
+
+const (
+	FieldSubject  TemplateField = "subject"
+	FieldTextBody TemplateField = "textBody"
+	FieldHtmlBody TemplateField = "htmlBody"
+)
+
+// RenderFunc renders a field of the template handed to the transport, the html body is
+// rendered using html/template while the subject and text body use text/template
+type RenderFunc func(field TemplateField, params map[string]interface{}) (string, error)
+
+// RenderedTemplate holds the rendered fields of a template, Locale is the locale of the template used
+type RenderedTemplate struct {
+	Locale   string `json:"locale"`
+	Subject  string `json:"subject"`
+	TextBody string `json:"textBody"`
+	HtmlBody string `json:"htmlBody"`
+}
+
+// compiledTemplate holds the parsed fields of a template, safe for concurrent execution
+type compiledTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+
+	// dependencies are the layouts and partials the template was assembled from
+	dependencies []templateKey
+}
+
+func (c *compiledTemplate) render(field TemplateField, params map[string]interface{}) (string, error) {
+	out := &bytes.Buffer{}
+
+	var err error
+
+	switch field {
+	case FieldSubject:
+		err = c.subject.Execute(out, params)
+
+	case FieldTextBody:
+		err = c.text.Execute(out, params)
+
+	case FieldHtmlBody:
+		err = c.html.Execute(out, params)
+
+	default:
+		return "", errors.Errorf("Unknown template field %s", field)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+func (c *compiledTemplate) dependsOn(key templateKey) bool {
+	for _, dependency := range c.dependencies {
+		if dependency == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *application) renderFunc(template Template) RenderFunc {
+	return func(field TemplateField, params map[string]interface{}) (string, error) {
+		compiled, err := a.cachedCompile(template)
+		if err != nil {
+			return "", err
+		}
+
+		return compiled.render(field, a.params(params))
+	}
+}
+
+func (a *application) uncachedRenderFunc(template Template) RenderFunc {
+	compiled, err := a.compile(template)
+
+	return func(field TemplateField, params map[string]interface{}) (string, error) {
+		if err != nil {
+			return "", err
+		}
+
+		return compiled.render(field, a.params(params))
+	}
+}
+
+// renderAll renders all fields of the template without using the cache, so it can be used to validate changes
+func (a *application) renderAll(template Template, job *Job) (subject, text, html string, err error) {
+	compiled, err := a.compile(template)
+	if err != nil {
+		return
+	}
+
+	params := a.params(job.Params)
+
+	subject, err = compiled.render(FieldSubject, params)
+	if err != nil {
+		return
+	}
+
+	text, err = compiled.render(FieldTextBody, params)
+	if err != nil {
+		return
+	}
+
+	html, err = compiled.render(FieldHtmlBody, params)
+	return
+}
+
+func (a *application) cachedCompile(template Template) (*compiledTemplate, error) {
+	if compiled, ok := a.templateCache.getCompiled(template); ok {
+		return compiled, nil
+	}
+
+	compiled, err := a.compile(template)
+	if err != nil {
+		return nil, err
+	}
+
+	a.templateCache.setCompiled(template, compiled)
+
+	return compiled, nil
+}
+
+func (a *application) compile(template Template) (*compiledTemplate, error) {
+	compiled := &compiledTemplate{}
+
+	// Templates declaring their parameters fail on missing ones instead of rendering <no value>
+	missingKey := "missingkey=default"
+	if len(template.Schema) > 0 {
+		missingKey = "missingkey=error"
+	}
+
+	compiled.subject = texttemplate.New(string(FieldSubject)).Funcs(texttemplate.FuncMap(a.templateFuncMap)).Option(missingKey)
+	if _, err := compiled.subject.Parse(template.Subject); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse subject")
+	}
+
+	compiled.text = texttemplate.New(string(FieldTextBody)).Funcs(texttemplate.FuncMap(a.templateFuncMap)).Option(missingKey)
+
+	textDependencies, err := a.assemble(&textSet{compiled.text}, string(FieldTextBody), template, func(t Template) (string, error) {
+		return t.TextBody, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse text body")
+	}
+
+	compiled.html = htmltemplate.New(string(FieldHtmlBody)).Funcs(a.templateFuncMap).Option(missingKey)
+
+	htmlDependencies, err := a.assemble(&htmlSet{compiled.html}, string(FieldHtmlBody), template, a.compileHtmlBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse html body")
+	}
+
+	compiled.dependencies = append(textDependencies, htmlDependencies...)
+
+	return compiled, nil
+}
+
+// params merges the static parameters into a copy of the job parameters
+func (a *application) params(params map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(params)+len(a.staticParams))
+
+	for key, value := range a.staticParams {
+		merged[key] = value
+	}
+
+	// Allow dynamic parameters to overwrite static parameters
+	for key, value := range params {
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// Render renders the template the way it would be sent, without creating placeholders for missing templates
+func (a *application) Render(id, locale string, parameters map[string]interface{}) (RenderedTemplate, error) {
+	rendered := RenderedTemplate{}
+
+	template, err := a.resolveTemplate(id, locale)
+	if err != nil {
+		return rendered, err
+	}
+
+	params, err := applySchema(&template, parameters)
+	if err != nil {
+		return rendered, err
+	}
+
+	render := a.renderFunc(template)
+	if a.cssInlining {
+		render = inlineCssRender(render)
+	}
+
+	rendered.Locale = template.Locale
+
+	if rendered.Subject, err = render(FieldSubject, params); err != nil {
+		return rendered, errors.Wrapf(err, "Failed to render subject of template %s", id)
+	}
+
+	if rendered.TextBody, err = render(FieldTextBody, params); err != nil {
+		return rendered, errors.Wrapf(err, "Failed to render text body of template %s", id)
+	}
+
+	if rendered.HtmlBody, err = render(FieldHtmlBody, params); err != nil {
+		return rendered, errors.Wrapf(err, "Failed to render html body of template %s", id)
+	}
+
+	return rendered, nil
+}
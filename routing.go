@@ -0,0 +1,125 @@
+package communication
+
+import (
+	"path"
+	"strings"
+)
+
+// Route sends the jobs matching all of its non-empty conditions through its transport
+type Route struct {
+	Type JobType
+
+	// CallingCodes matches sms targets in international format, e.g. "46" for +46701234567
+	CallingCodes []string
+	// EmailDomains matches the domain of email targets
+	EmailDomains []string
+	// TemplateIds matches template ids using path.Match patterns, e.g. "marketing-*"
+	TemplateIds []string
+	// Locales matches the job locale, "sv" also matches regional locales like "sv-FI"
+	Locales []string
+
+	Transport Transport
+}
+
+// AddRoute adds a routing rule, routes are evaluated in the order they were added
+// and jobs not matching any route are sent through the default transport
+func AddRoute(route Route) AppOption {
+	return func(a *application) {
+		a.routes = append(a.routes, route)
+	}
+}
+
+func (r Route) matches(job *Job) bool {
+	if r.Type != "" && r.Type != job.Type {
+		return false
+	}
+
+	if len(r.CallingCodes) > 0 && !matchCallingCode(r.CallingCodes, job.Target) {
+		return false
+	}
+
+	if len(r.EmailDomains) > 0 && !matchEmailDomain(r.EmailDomains, job.Target) {
+		return false
+	}
+
+	if len(r.TemplateIds) > 0 && !matchTemplateId(r.TemplateIds, job.TemplateId) {
+		return false
+	}
+
+	if len(r.Locales) > 0 && !matchLocale(r.Locales, job.Locale) {
+		return false
+	}
+
+	return true
+}
+
+func matchCallingCode(codes []string, target string) bool {
+	number := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r == '+' {
+			return r
+		}
+
+		return -1
+	}, target)
+
+	switch {
+	case strings.HasPrefix(number, "+"):
+		number = number[1:]
+
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+
+	default:
+		// Numbers in national format do not carry a calling code
+		return false
+	}
+
+	for _, code := range codes {
+		if strings.HasPrefix(number, strings.TrimPrefix(code, "+")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchEmailDomain(domains []string, target string) bool {
+	at := strings.LastIndex(target, "@")
+	if at < 0 {
+		return false
+	}
+
+	domain := target[at+1:]
+
+	for _, d := range domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchTemplateId(patterns []string, templateId string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, templateId); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchLocale(locales []string, locale string) bool {
+	for _, l := range locales {
+		if strings.EqualFold(l, locale) {
+			return true
+		}
+
+		if len(locale) > len(l) && strings.EqualFold(l, locale[:len(l)]) && (locale[len(l)] == '-' || locale[len(l)] == '_') {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,275 @@
+package communication
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type ParameterType string
+
+const (
+	ParameterString  ParameterType = "string"
+	ParameterNumber  ParameterType = "number"
+	ParameterInteger ParameterType = "integer"
+	ParameterBoolean ParameterType = "boolean"
+	ParameterObject  ParameterType = "object"
+	ParameterArray   ParameterType = "array"
+)
+
+type ParameterFormat string
+
+const (
+	FormatEmail    ParameterFormat = "email"
+	FormatUrl      ParameterFormat = "url"
+	FormatDateTime ParameterFormat = "date-time"
+)
+
+// ParameterSpec declares a parameter a template expects
+type ParameterSpec struct {
+	Name        string          `json:"name"`
+	Type        ParameterType   `json:"type"`
+	Format      ParameterFormat `json:"format,omitempty"`
+	Required    bool            `json:"required"`
+	Default     interface{}     `json:"default,omitempty"`
+	Description string          `json:"description,omitempty"`
+}
+
+// ParameterSchema declares the parameters of a template, templates with a schema
+// fail to render when referencing parameters that were not provided
+type ParameterSchema []ParameterSpec
+
+// InvalidParametersError is returned when parameters do not match the schema of a template
+type InvalidParametersError struct {
+	TemplateId string
+	Violations []string
+}
+
+func (e *InvalidParametersError) Error() string {
+	return fmt.Sprintf("Invalid parameters for template %s: %s", e.TemplateId, strings.Join(e.Violations, ", "))
+}
+
+// Validate checks that the schema itself is usable
+func (schema ParameterSchema) Validate() error {
+	seen := map[string]bool{}
+
+	for _, spec := range schema {
+		if spec.Name == "" {
+			return errors.New("Parameter is missing a name")
+		}
+
+		if seen[spec.Name] {
+			return errors.Errorf("Parameter %s is declared twice", spec.Name)
+		}
+
+		seen[spec.Name] = true
+
+		switch spec.Type {
+		case "", ParameterString, ParameterNumber, ParameterInteger, ParameterBoolean, ParameterObject, ParameterArray:
+
+		default:
+			return errors.Errorf("Parameter %s has unknown type %s", spec.Name, spec.Type)
+		}
+
+		switch spec.Format {
+		case "", FormatEmail, FormatUrl, FormatDateTime:
+
+		default:
+			return errors.Errorf("Parameter %s has unknown format %s", spec.Name, spec.Format)
+		}
+
+		if spec.Default != nil {
+			if violation := spec.check(spec.Default); violation != "" {
+				return errors.Errorf("Default of parameter %s: %s", spec.Name, violation)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Apply validates the parameters and returns a copy with the defaults filled in, optional parameters
+// without a default are set to an empty value of their type
+func (schema ParameterSchema) Apply(templateId string, params map[string]interface{}) (map[string]interface{}, error) {
+	applied := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		applied[key] = value
+	}
+
+	var violations []string
+
+	for _, spec := range schema {
+		value, ok := applied[spec.Name]
+		if !ok || value == nil {
+			switch {
+			case spec.Default != nil:
+				applied[spec.Name] = spec.Default
+
+			case spec.Required:
+				violations = append(violations, fmt.Sprintf("%s is required", spec.Name))
+
+			default:
+				// Templates with a schema fail on missing keys, so optional parameters are always set
+				applied[spec.Name] = spec.zero()
+			}
+
+			continue
+		}
+
+		if violation := spec.check(value); violation != "" {
+			violations = append(violations, fmt.Sprintf("%s %s", spec.Name, violation))
+		}
+	}
+
+	if len(violations) > 0 {
+		return applied, &InvalidParametersError{TemplateId: templateId, Violations: violations}
+	}
+
+	return applied, nil
+}
+
+// Example fills in the declared parameters missing from params with their default or
+// an empty value of their type, used to validate templates against the sample parameters
+func (schema ParameterSchema) Example(params map[string]interface{}) map[string]interface{} {
+	example := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		example[key] = value
+	}
+
+	for _, spec := range schema {
+		if _, ok := example[spec.Name]; ok {
+			continue
+		}
+
+		if spec.Default != nil {
+			example[spec.Name] = spec.Default
+			continue
+		}
+
+		example[spec.Name] = spec.zero()
+	}
+
+	return example
+}
+
+// zero returns an empty value of the type of the parameter
+func (spec ParameterSpec) zero() interface{} {
+	switch spec.Type {
+	case ParameterNumber, ParameterInteger:
+		return 0
+
+	case ParameterBoolean:
+		return false
+
+	case ParameterObject:
+		return map[string]interface{}{}
+
+	case ParameterArray:
+		return []interface{}{}
+	}
+
+	return ""
+}
+
+// check returns a description of why value does not match the spec, or an empty string
+func (spec ParameterSpec) check(value interface{}) string {
+	if !matchesType(spec.Type, value) {
+		return fmt.Sprintf("must be of type %s", spec.Type)
+	}
+
+	if spec.Format == "" {
+		return ""
+	}
+
+	if t, ok := value.(time.Time); ok && spec.Format == FormatDateTime && !t.IsZero() {
+		return ""
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Sprintf("must be a string in %s format", spec.Format)
+	}
+
+	switch spec.Format {
+	case FormatEmail:
+		if _, err := mail.ParseAddress(str); err != nil {
+			return "must be an email address"
+		}
+
+	case FormatUrl:
+		if u, err := url.Parse(str); err != nil || u.Scheme == "" || u.Host == "" {
+			return "must be an absolute url"
+		}
+
+	case FormatDateTime:
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			return "must be a RFC3339 date-time"
+		}
+	}
+
+	return ""
+}
+
+func matchesType(t ParameterType, value interface{}) bool {
+	if n, ok := value.(json.Number); ok {
+		if t == ParameterInteger {
+			_, err := n.Int64()
+			return err == nil
+		}
+
+		return t == "" || t == ParameterNumber
+	}
+
+	if _, ok := value.(time.Time); ok {
+		return t == "" || t == ParameterString
+	}
+
+	kind := reflect.ValueOf(value).Kind()
+
+	switch t {
+	case "":
+		return true
+
+	case ParameterString:
+		return kind == reflect.String
+
+	case ParameterNumber:
+		return kind >= reflect.Int && kind <= reflect.Float64
+
+	case ParameterInteger:
+		if kind == reflect.Float32 || kind == reflect.Float64 {
+			f := reflect.ValueOf(value).Float()
+			return f == math.Trunc(f)
+		}
+
+		return kind >= reflect.Int && kind <= reflect.Uint64
+
+	case ParameterBoolean:
+		return kind == reflect.Bool
+
+	case ParameterObject:
+		return kind == reflect.Map || kind == reflect.Struct || kind == reflect.Ptr
+
+	case ParameterArray:
+		return kind == reflect.Slice || kind == reflect.Array
+
+	default:
+		return false
+	}
+}
+
+// applySchema validates the parameters against the schema of the template, tpl is nil for templates created on demand
+func applySchema(tpl *Template, params map[string]interface{}) (map[string]interface{}, error) {
+	if tpl == nil || len(tpl.Schema) == 0 {
+		return params, nil
+	}
+
+	return tpl.Schema.Apply(tpl.TemplateId, params)
+}
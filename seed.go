@@ -0,0 +1,299 @@
+package communication
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// seedMeta is the optional meta.json of a seeded template
+type seedMeta struct {
+	Enabled     *bool                  `json:"enabled"`
+	Description string                 `json:"description"`
+	Kind        TemplateKind           `json:"kind"`
+	Layout      string                 `json:"layout"`
+	Parameters  map[string]interface{} `json:"parameters"`
+	Schema      ParameterSchema        `json:"schema"`
+
+	FromName    string `json:"fromName"`
+	FromAddress string `json:"fromAddress"`
+	ReplyTo     string `json:"replyTo"`
+	SmsSender   string `json:"smsSender"`
+
+	SmsMaxSegments int            `json:"smsMaxSegments"`
+	SmsLimitAction SmsLimitAction `json:"smsLimitAction"`
+}
+
+// SetTemplateSeed creates the templates of the filesystem missing from the repository when the application
+// is created, templates are read from <id>/<locale>/ directories containing subject.txt, body.txt, one of
+// body.html, body.md or body.mjml and an optional meta.json. With updateUnedited seeded templates that
+// were not changed since are updated when the files change
+func SetTemplateSeed(fsys fs.FS, updateUnedited bool) AppOption {
+	return func(a *application) {
+		a.templateSeed = fsys
+		a.updateSeededTemplates = updateUnedited
+	}
+}
+
+func (a *application) seedTemplates() error {
+	seeds, err := ReadTemplates(a.templateSeed)
+	if err != nil {
+		return err
+	}
+
+	for i, seed := range seeds {
+		kind := seed.Kind
+		if kind == "" {
+			kind = TemplateKindContent
+		}
+
+		if seed.TextBody == "" && a.htmlToTextConverter != nil && kind == TemplateKindContent {
+			html, err := a.compileHtmlBody(seed)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to compile html body of seeded template %s for locale %s", seed.TemplateId, seed.Locale)
+			}
+
+			seeds[i].TextBody = a.htmlToTextConverter(html)
+			seeds[i].SeedChecksum = seedChecksum(seeds[i])
+		}
+	}
+
+	// All seeds are validated before any of them is stored, resolving layouts and partials among them
+	validator := a.validatorWith(seeds)
+
+	for _, seed := range seeds {
+		if _, err := validator.validateTemplate(seed); err != nil {
+			return errors.Wrapf(err, "Invalid seeded template %s for locale %s", seed.TemplateId, seed.Locale)
+		}
+	}
+
+	for _, seed := range seeds {
+		existing, err := a.templateRepo.Get(seed.TemplateId, seed.Locale)
+		switch err {
+		case TemplateNotFoundErr:
+			if err := a.createTemplate(&seed, versionOf(seed)); err != nil {
+				return errors.Wrapf(err, "Failed to create seeded template %s for locale %s", seed.TemplateId, seed.Locale)
+			}
+
+		case nil:
+			if existing.SeedChecksum == seed.SeedChecksum || !a.replacesWithSeed(existing) {
+				continue
+			}
+
+			template := existing
+
+			newTemplateVersion(seed).restore(&template)
+			template.Kind = seed.Kind
+			template.SeedChecksum = seed.SeedChecksum
+			template.Version++
+
+			// Whether a seeded template is enabled is left to the administration panel once created
+			if existing.Subject == placeholderSubject {
+				template.Enabled = seed.Enabled
+			}
+
+			if seed.Parameters != nil {
+				template.Parameters = seed.Parameters
+				template.UpdateParameters = false
+			}
+
+			if err := a.saveTemplate(existing, &template, versionOf(template)); err != nil {
+				return errors.Wrapf(err, "Failed to update seeded template %s for locale %s", seed.TemplateId, seed.Locale)
+			}
+
+		default:
+			return errors.Wrapf(err, "Failed to retrieve template %s for locale %s", seed.TemplateId, seed.Locale)
+		}
+	}
+
+	return nil
+}
+
+// replacesWithSeed returns if the template may be overwritten by its seed, placeholders are always
+// replaced while seeded templates are only replaced when they have not been edited since
+func (a *application) replacesWithSeed(template Template) bool {
+	if template.Subject == placeholderSubject {
+		return true
+	}
+
+	return a.updateSeededTemplates && template.SeedChecksum != "" && template.SeedChecksum == seedChecksum(template)
+}
+
+func versionOf(template Template) *TemplateVersion {
+	version := newTemplateVersion(template)
+
+	return &version
+}
+
+// seedContent is the content hashed by seedChecksum, fields are listed explicitly so checksums of
+// seeded templates stay the same when fields are added to templates or versions
+type seedContent struct {
+	Kind        TemplateKind
+	Description string
+	Layout      string
+	Schema      ParameterSchema
+
+	Subject    string
+	TextBody   string
+	HtmlBody   string
+	BodyFormat BodyFormat
+
+	FromName    string
+	FromAddress string
+	ReplyTo     string
+	SmsSender   string
+
+	SmsMaxSegments int
+	SmsLimitAction SmsLimitAction
+}
+
+// seedChecksum hashes the content of a template to detect changes made after seeding
+func seedChecksum(template Template) string {
+	data, _ := json.Marshal(seedContent{
+		Kind:        template.Kind,
+		Description: template.Description,
+		Layout:      template.Layout,
+		Schema:      template.Schema,
+
+		Subject:    template.Subject,
+		TextBody:   template.TextBody,
+		HtmlBody:   template.HtmlBody,
+		BodyFormat: template.BodyFormat,
+
+		FromName:    template.FromName,
+		FromAddress: template.FromAddress,
+		ReplyTo:     template.ReplyTo,
+		SmsSender:   template.SmsSender,
+
+		SmsMaxSegments: template.SmsMaxSegments,
+		SmsLimitAction: template.SmsLimitAction,
+	})
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadTemplates reads the templates of a filesystem laid out as described for SetTemplateSeed
+func ReadTemplates(fsys fs.FS) ([]Template, error) {
+	var templates []Template
+
+	ids, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return templates, errors.Wrap(err, "Failed to read templates")
+	}
+
+	for _, id := range ids {
+		if !id.IsDir() || strings.HasPrefix(id.Name(), ".") {
+			continue
+		}
+
+		locales, err := fs.ReadDir(fsys, id.Name())
+		if err != nil {
+			return templates, errors.Wrapf(err, "Failed to read template %s", id.Name())
+		}
+
+		for _, locale := range locales {
+			if !locale.IsDir() || strings.HasPrefix(locale.Name(), ".") {
+				continue
+			}
+
+			template, err := readSeedTemplate(fsys, id.Name(), locale.Name())
+			if err != nil {
+				return templates, err
+			}
+
+			templates = append(templates, template)
+		}
+	}
+
+	return templates, nil
+}
+
+func readSeedTemplate(fsys fs.FS, templateId, locale string) (Template, error) {
+	template := Template{
+		TemplateId: templateId,
+		Locale:     locale,
+		Enabled:    true,
+		Version:    1,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+
+	dir := path.Join(templateId, locale)
+
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return template, errors.Wrapf(err, "Failed to read template %s", dir)
+	}
+
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, file.Name()))
+		if err != nil {
+			return template, errors.Wrapf(err, "Failed to read template file %s/%s", dir, file.Name())
+		}
+
+		switch file.Name() {
+		case "subject.txt":
+			template.Subject = strings.TrimRight(string(data), "\r\n")
+
+		case "body.txt":
+			template.TextBody = string(data)
+
+		case "body.html", "body.md", "body.mjml":
+			if template.HtmlBody != "" {
+				return template, errors.Errorf("Template %s has more than one html body", dir)
+			}
+
+			template.HtmlBody = string(data)
+			template.BodyFormat = map[string]BodyFormat{
+				"body.html": BodyFormatHtml,
+				"body.md":   BodyFormatMarkdown,
+				"body.mjml": BodyFormatMjml,
+			}[file.Name()]
+
+		case "meta.json":
+			meta := seedMeta{}
+			if err := json.Unmarshal(data, &meta); err != nil {
+				return template, errors.Wrapf(err, "Invalid meta.json for template %s", dir)
+			}
+
+			if meta.Enabled != nil {
+				template.Enabled = *meta.Enabled
+			}
+
+			if err := meta.Schema.Validate(); err != nil {
+				return template, errors.Wrapf(err, "Invalid schema for template %s", dir)
+			}
+
+			template.Description = meta.Description
+			template.Kind = meta.Kind
+			template.Layout = meta.Layout
+			template.Parameters = meta.Parameters
+			template.Schema = meta.Schema
+			template.FromName = meta.FromName
+			template.FromAddress = meta.FromAddress
+			template.ReplyTo = meta.ReplyTo
+			template.SmsSender = meta.SmsSender
+			template.SmsMaxSegments = meta.SmsMaxSegments
+			template.SmsLimitAction = meta.SmsLimitAction
+
+		default:
+			return template, errors.Errorf("Unknown file %s in template %s", file.Name(), dir)
+		}
+	}
+
+	template.SeedChecksum = seedChecksum(template)
+
+	return template, nil
+}
@@ -0,0 +1,193 @@
+package communication
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// SmsEncoding is the encoding an sms body will be sent with
+type SmsEncoding string
+
+const (
+	SmsEncodingGsm7 SmsEncoding = "GSM-7"
+	SmsEncodingUcs2 SmsEncoding = "UCS-2"
+)
+
+const (
+	gsm7Basic     = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extension = "\f^{}\\[~]|€"
+)
+
+// SmsAnalysis describes how an sms body will be split into segments by the operator
+type SmsAnalysis struct {
+	Encoding SmsEncoding `json:"encoding"`
+	// Length is counted in septets for GSM-7, where extension characters count twice, and in UTF-16 code units for UCS-2
+	Length   int `json:"length"`
+	Segments int `json:"segments"`
+
+	// NonGsmCharacters are the characters forcing the body to be sent as UCS-2
+	NonGsmCharacters []string `json:"nonGsmCharacters"`
+
+	// Transliterated is set when the body was transliterated to fit the segment limit of the template
+	Transliterated bool `json:"transliterated"`
+}
+
+// AnalyzeSms calculates the encoding and number of segments of an sms body
+func AnalyzeSms(body string) SmsAnalysis {
+	analysis := SmsAnalysis{
+		Encoding:         SmsEncodingGsm7,
+		NonGsmCharacters: []string{},
+	}
+
+	seen := map[rune]bool{}
+
+	for _, r := range body {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			analysis.Length++
+
+		case strings.ContainsRune(gsm7Extension, r):
+			analysis.Length += 2
+
+		default:
+			analysis.Encoding = SmsEncodingUcs2
+
+			if !seen[r] {
+				seen[r] = true
+				analysis.NonGsmCharacters = append(analysis.NonGsmCharacters, string(r))
+			}
+		}
+	}
+
+	single, multi := 160, 153
+
+	if analysis.Encoding == SmsEncodingUcs2 {
+		analysis.Length = len(utf16.Encode([]rune(body)))
+		single, multi = 70, 67
+	}
+
+	switch {
+	case analysis.Length == 0:
+		analysis.Segments = 0
+
+	case analysis.Length <= single:
+		analysis.Segments = 1
+
+	default:
+		analysis.Segments = (analysis.Length + multi - 1) / multi
+	}
+
+	return analysis
+}
+
+// SmsLimitAction is what happens to sms bodies exceeding the segment limit of their template
+type SmsLimitAction string
+
+const (
+	// SmsLimitFail fails the job, this is the default
+	SmsLimitFail SmsLimitAction = "fail"
+	// SmsLimitTransliterate replaces the characters outside GSM-7 and fails the job if it is still too long
+	SmsLimitTransliterate SmsLimitAction = "transliterate"
+)
+
+var SmsTooLongErr = errors.New("The sms exceeds the segment limit of the template")
+
+// smsTransliterations are replacements for common characters outside GSM-7
+var smsTransliterations = map[rune]string{
+	'‘': "'", '’': "'", '‚': "'", '′': "'",
+	'“': "\"", '”': "\"", '„': "\"", '″': "\"",
+	'–': "-", '—': "-", '‐': "-", '−': "-",
+	'…': "...", '•': "*", '×': "x",
+	'\u00a0': " ", '\u2009': " ", '\u200b': "", '\t': " ",
+	'ç': "Ç", 'Ð': "D", 'ð': "d", 'Þ': "Th", 'þ': "th", 'Œ': "OE", 'œ': "oe",
+}
+
+// TransliterateSms replaces the characters of the body outside GSM-7 with the closest GSM-7 characters,
+// dropping accents where needed and replacing characters without equivalent with a question mark
+func TransliterateSms(body string) string {
+	out := &strings.Builder{}
+
+	for _, r := range body {
+		if strings.ContainsRune(gsm7Basic, r) || strings.ContainsRune(gsm7Extension, r) {
+			out.WriteRune(r)
+			continue
+		}
+
+		if replacement, ok := smsTransliterations[r]; ok {
+			out.WriteString(replacement)
+			continue
+		}
+
+		// Decompose accented characters and keep the base characters available in GSM-7
+		replaced := false
+
+		for _, d := range norm.NFD.String(string(r)) {
+			if unicode.Is(unicode.Mn, d) {
+				continue
+			}
+
+			if strings.ContainsRune(gsm7Basic, d) {
+				out.WriteRune(d)
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			out.WriteRune('?')
+		}
+	}
+
+	return out.String()
+}
+
+// limitSms applies the segment limit of the template to a rendered sms body
+func limitSms(template Template, body string) (string, SmsAnalysis, error) {
+	analysis := AnalyzeSms(body)
+
+	if template.SmsMaxSegments <= 0 || analysis.Segments <= template.SmsMaxSegments {
+		return body, analysis, nil
+	}
+
+	if template.SmsLimitAction == SmsLimitTransliterate {
+		body = TransliterateSms(body)
+		analysis = AnalyzeSms(body)
+		analysis.Transliterated = true
+
+		if analysis.Segments <= template.SmsMaxSegments {
+			return body, analysis, nil
+		}
+	}
+
+	return body, analysis, errors.Wrapf(SmsTooLongErr, "Template %s allows %d segments, the sms needs %d", template.TemplateId, template.SmsMaxSegments, analysis.Segments)
+}
+
+// smsLimitRender enforces the segment limit of the template on the text body sent as sms
+func smsLimitRender(render RenderFunc, template Template) RenderFunc {
+	return func(field TemplateField, params map[string]interface{}) (string, error) {
+		body, err := render(field, params)
+		if err != nil || field != FieldTextBody {
+			return body, err
+		}
+
+		body, _, err = limitSms(template, body)
+
+		return body, err
+	}
+}
+
+func validateSmsLimit(template Template) error {
+	if template.SmsMaxSegments < 0 {
+		return errors.New("The sms segment limit can not be negative")
+	}
+
+	switch template.SmsLimitAction {
+	case "", SmsLimitFail, SmsLimitTransliterate:
+		return nil
+	}
+
+	return errors.Errorf("Unsupported sms limit action %s", template.SmsLimitAction)
+}
@@ -6,12 +6,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
 
 var (
 	TemplateNotFoundErr = errors.New("The template was not found")
 	JobNotFoundErr      = errors.New("The transaction was not found")
+
+	InboxMessageNotFoundErr    = errors.New("The inbox message was not found")
+	InboxMessageExistsErr      = errors.New("The inbox message already exists")
+	TemplateVersionNotFoundErr = errors.New("The template version was not found")
 )
 
 var templateSortingMap = map[string]string{
@@ -26,6 +31,11 @@ var jobSortingMap = map[string]string{
 	"createdAt": "created_at",
 }
 
+var inboxSortingMap = map[string]string{
+	"readAt":    "read_at",
+	"createdAt": "created_at",
+}
+
 type TemplateCriteria struct {
 	Offset int
 	Limit  int
@@ -33,6 +43,7 @@ type TemplateCriteria struct {
 	Locale     string
 	TemplateId string
 	Subject    string
+	Kind       string
 
 	UpdatedAfter  time.Time
 	UpdatedBefore time.Time
@@ -50,6 +61,7 @@ func PopulateTemplateCriteria(r *http.Request) TemplateCriteria {
 	criteria.Locale = r.FormValue("locale")
 	criteria.Subject = r.FormValue("subject")
 	criteria.TemplateId = r.FormValue("templateId")
+	criteria.Kind = r.FormValue("kind")
 
 	if after, err := time.Parse(time.RFC3339, r.FormValue("updatedAfter")); err == nil {
 		criteria.UpdatedAfter = after
@@ -107,7 +119,13 @@ type JobCriteria struct {
 	TemplateId string
 	Locale     string
 	Target     string
+	Recipient  string
 	ExternalId string
+	ChainUuid  string
+	Held       bool
+
+	// Escalating matches jobs waiting for a delivery confirmation before escalating
+	Escalating bool
 
 	SentAfter  time.Time
 	SentBefore time.Time
@@ -125,6 +143,9 @@ func PopulateJobCriteria(r *http.Request) JobCriteria {
 	criteria.Type = r.FormValue("type")
 	criteria.Locale = r.FormValue("locale")
 	criteria.TemplateId = r.FormValue("templateId")
+	criteria.ChainUuid = r.FormValue("chainUuid")
+	criteria.Recipient = r.FormValue("recipient")
+	criteria.Held = r.FormValue("held") == "true"
 
 	if after, err := time.Parse(time.RFC3339, r.FormValue("sentAfter")); err == nil {
 		criteria.SentAfter = after
@@ -163,9 +184,108 @@ func PopulateJobCriteria(r *http.Request) JobCriteria {
 }
 
 type JobRepository interface {
+	Get(uuid uuid.UUID) (Job, error)
 	GetPending() ([]Job, error)
 	Matching(criteria JobCriteria) ([]Job, int, error)
 
 	Create(*Job) error
 	Update(*Job) error
 }
+
+type InboxCriteria struct {
+	Limit  int
+	Offset int
+
+	Recipient  string
+	TemplateId string
+	Unread     bool
+
+	Sorting map[string]string
+}
+
+func PopulateInboxCriteria(r *http.Request) InboxCriteria {
+	criteria := InboxCriteria{
+		Offset:  0,
+		Limit:   10,
+		Sorting: map[string]string{},
+	}
+
+	criteria.TemplateId = r.FormValue("templateId")
+
+	if unread, err := strconv.ParseBool(r.FormValue("unread")); err == nil {
+		criteria.Unread = unread
+	}
+
+	if limit, err := strconv.ParseInt(r.FormValue("limit"), 10, 64); err == nil {
+		criteria.Limit = int(limit)
+	}
+
+	if offset, err := strconv.ParseInt(r.FormValue("offset"), 10, 64); err == nil {
+		criteria.Offset = int(offset)
+	}
+
+	if sorting := r.FormValue("sorting"); sorting != "" {
+		sorts := strings.Split(sorting, ",")
+
+		for _, sort := range sorts {
+			split := strings.Split(sort, ":")
+			// Remove invalid splits
+			if len(split) != 2 || (split[1] != "asc" && split[1] != "desc") {
+				continue
+			}
+
+			// Only allow sorting on specific fields
+			if column, ok := inboxSortingMap[split[0]]; ok {
+				criteria.Sorting[column] = split[1]
+			}
+		}
+	} else {
+		criteria.Sorting["created_at"] = "desc"
+	}
+
+	return criteria
+}
+
+type InboxRepository interface {
+	Get(uuid uuid.UUID) (InboxMessage, error)
+	Matching(criteria InboxCriteria) ([]InboxMessage, int, error)
+	CountUnread(recipient string) (int, error)
+
+	Create(message *InboxMessage) error
+	Update(message *InboxMessage) error
+	Delete(message *InboxMessage) error
+}
+
+type TemplateVersionCriteria struct {
+	Limit  int
+	Offset int
+
+	TemplateId string
+	Locale     string
+}
+
+func PopulateTemplateVersionCriteria(r *http.Request) TemplateVersionCriteria {
+	criteria := TemplateVersionCriteria{
+		Offset: 0,
+		Limit:  10,
+	}
+
+	if limit, err := strconv.ParseInt(r.FormValue("limit"), 10, 64); err == nil {
+		criteria.Limit = int(limit)
+	}
+
+	if offset, err := strconv.ParseInt(r.FormValue("offset"), 10, 64); err == nil {
+		criteria.Offset = int(offset)
+	}
+
+	return criteria
+}
+
+// TemplateVersionRepository stores template versions, which are never updated once created
+type TemplateVersionRepository interface {
+	Get(id, locale string, version int) (TemplateVersion, error)
+	// Matching returns the versions of a template with the newest first
+	Matching(criteria TemplateVersionCriteria) ([]TemplateVersion, int, error)
+
+	Create(version *TemplateVersion) error
+}
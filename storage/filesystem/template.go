@@ -0,0 +1,289 @@
+package filesystem
+
+import (
+	"context"
+	"io/fs"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/interactive-solutions/go-communication"
+	"github.com/pkg/errors"
+)
+
+var ReadOnlyErr = errors.New("The filesystem template repository is read only")
+
+var (
+	_ communication.TemplateRepository  = &TemplateRepository{}
+	_ communication.TemplateInvalidator = &TemplateRepository{}
+)
+
+// columns the templates can be sorted on, in the order they are compared
+var sortColumns = []string{"template_id", "locale", "enabled", "updated_at", "created_at"}
+
+type templateKey struct {
+	templateId string
+	locale     string
+}
+
+// TemplateRepository serves templates read from a filesystem laid out like seeded templates, use os.DirFS
+// for a directory. It is also a template invalidator notifying the application of templates changed by Reload
+type TemplateRepository struct {
+	fsys fs.FS
+
+	mutex     sync.RWMutex
+	templates map[templateKey]communication.Template
+	handlers  []func(templateId, locale string)
+}
+
+// NewTemplateRepository creates a read only template repository loading the templates of the filesystem
+func NewTemplateRepository(fsys fs.FS) (*TemplateRepository, error) {
+	repo := &TemplateRepository{
+		fsys:      fsys,
+		templates: map[templateKey]communication.Template{},
+	}
+
+	return repo, repo.Reload()
+}
+
+// Reload reads the filesystem again, keeping the current templates if it fails
+func (repo *TemplateRepository) Reload() error {
+	loaded, err := communication.ReadTemplates(repo.fsys)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	templates := make(map[templateKey]communication.Template, len(loaded))
+
+	repo.mutex.Lock()
+
+	var changed []templateKey
+
+	for _, template := range loaded {
+		key := templateKey{template.TemplateId, template.Locale}
+
+		// Keep the timestamps of unchanged templates, the application caches compiled templates by update time
+		if current, ok := repo.templates[key]; ok {
+			template.CreatedAt = current.CreatedAt
+			template.UpdatedAt = current.UpdatedAt
+
+			if !reflect.DeepEqual(template, current) {
+				template.UpdatedAt = now
+				changed = append(changed, key)
+			}
+		} else {
+			template.CreatedAt = now
+			template.UpdatedAt = now
+		}
+
+		templates[key] = template
+	}
+
+	for key := range repo.templates {
+		if _, ok := templates[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+
+	repo.templates = templates
+	handlers := repo.handlers
+
+	repo.mutex.Unlock()
+
+	for _, key := range changed {
+		for _, handler := range handlers {
+			handler(key.templateId, key.locale)
+		}
+	}
+
+	return nil
+}
+
+// Watch reloads the templates at the given interval until the context is done
+func (repo *TemplateRepository) Watch(ctx context.Context, interval time.Duration, onError func(err error)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-ticker.C:
+				if err := repo.Reload(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+func (repo *TemplateRepository) Get(id, locale string) (communication.Template, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	template, ok := repo.templates[templateKey{id, locale}]
+	if !ok {
+		return template, communication.TemplateNotFoundErr
+	}
+
+	return template, nil
+}
+
+func (repo *TemplateRepository) Matching(criteria communication.TemplateCriteria) ([]communication.Template, int, error) {
+	repo.mutex.RLock()
+
+	matching := make([]communication.Template, 0)
+
+	for _, template := range repo.templates {
+		if matches(template, criteria) {
+			matching = append(matching, template)
+		}
+	}
+
+	repo.mutex.RUnlock()
+
+	sort.Slice(matching, func(i, j int) bool {
+		for _, column := range sortColumns {
+			dir, ok := criteria.Sorting[column]
+			if !ok {
+				continue
+			}
+
+			if cmp := compare(matching[i], matching[j], column); cmp != 0 {
+				return (cmp < 0) == (dir != "desc")
+			}
+		}
+
+		// Ties are ordered by id and locale so pages do not depend on the map order
+		if cmp := compare(matching[i], matching[j], "template_id"); cmp != 0 {
+			return cmp < 0
+		}
+
+		return compare(matching[i], matching[j], "locale") < 0
+	})
+
+	count := len(matching)
+
+	if criteria.Offset >= count {
+		return []communication.Template{}, count, nil
+	}
+
+	matching = matching[criteria.Offset:]
+
+	if criteria.Limit > 0 && criteria.Limit < len(matching) {
+		matching = matching[:criteria.Limit]
+	}
+
+	return matching, count, nil
+}
+
+func (repo *TemplateRepository) Create(template *communication.Template) error {
+	return ReadOnlyErr
+}
+
+func (repo *TemplateRepository) Update(template *communication.Template) error {
+	return ReadOnlyErr
+}
+
+func (repo *TemplateRepository) Delete(template *communication.Template) error {
+	return ReadOnlyErr
+}
+
+// Publish does nothing as templates can only be changed on the filesystem
+func (repo *TemplateRepository) Publish(templateId, locale string) error {
+	return nil
+}
+
+// Subscribe registers a handler called for each template changed when reloading
+func (repo *TemplateRepository) Subscribe(ctx context.Context, handler func(templateId, locale string)) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.handlers = append(repo.handlers, handler)
+
+	return nil
+}
+
+func matches(template communication.Template, criteria communication.TemplateCriteria) bool {
+	if criteria.TemplateId != "" && !strings.HasPrefix(template.TemplateId, criteria.TemplateId) {
+		return false
+	}
+
+	if criteria.Locale != "" && !strings.EqualFold(template.Locale, criteria.Locale) {
+		return false
+	}
+
+	switch communication.TemplateKind(criteria.Kind) {
+	case "":
+
+	case communication.TemplateKindContent:
+		if template.Kind != "" && template.Kind != communication.TemplateKindContent {
+			return false
+		}
+
+	default:
+		if string(template.Kind) != criteria.Kind {
+			return false
+		}
+	}
+
+	if criteria.Subject != "" && !strings.HasPrefix(strings.ToLower(template.Subject), strings.ToLower(criteria.Subject)) {
+		return false
+	}
+
+	if !criteria.UpdatedAfter.IsZero() && template.UpdatedAt.Before(criteria.UpdatedAfter) {
+		return false
+	}
+
+	if !criteria.UpdatedBefore.IsZero() && template.UpdatedAt.After(criteria.UpdatedBefore) {
+		return false
+	}
+
+	return true
+}
+
+func compare(a, b communication.Template, column string) int {
+	switch column {
+	case "template_id":
+		return strings.Compare(a.TemplateId, b.TemplateId)
+
+	case "locale":
+		return strings.Compare(a.Locale, b.Locale)
+
+	case "enabled":
+		switch {
+		case a.Enabled == b.Enabled:
+			return 0
+
+		case b.Enabled:
+			return -1
+		}
+
+		return 1
+
+	case "updated_at":
+		return compareTimes(a.UpdatedAt, b.UpdatedAt)
+
+	case "created_at":
+		return compareTimes(a.CreatedAt, b.CreatedAt)
+	}
+
+	return 0
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+
+	case a.After(b):
+		return 1
+	}
+
+	return 0
+}
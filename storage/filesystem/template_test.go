@@ -0,0 +1,143 @@
+package filesystem
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/interactive-solutions/go-communication"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateRepositoryReload(t *testing.T) {
+	files := fstest.MapFS{
+		"welcome/en/subject.txt": {Data: []byte("Welcome")},
+		"welcome/sv/subject.txt": {Data: []byte("Välkommen")},
+		"reset/en/subject.txt":   {Data: []byte("Reset your password")},
+	}
+
+	repo, err := NewTemplateRepository(files)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var changed []string
+	assert.NoError(t, repo.Subscribe(context.Background(), func(templateId, locale string) {
+		changed = append(changed, locale+":"+templateId)
+	}))
+
+	welcome, err := repo.Get("welcome", "sv")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, repo.Reload())
+	assert.Empty(t, changed, "Unchanged templates should not be reported")
+
+	unchanged, _ := repo.Get("welcome", "sv")
+	assert.Equal(t, welcome.UpdatedAt, unchanged.UpdatedAt)
+
+	files["welcome/en/subject.txt"] = &fstest.MapFile{Data: []byte("Welcome aboard")}
+	delete(files, "reset/en/subject.txt")
+	delete(files, "reset/en")
+	delete(files, "reset")
+
+	assert.NoError(t, repo.Reload())
+	assert.ElementsMatch(t, []string{"en:welcome", "en:reset"}, changed)
+
+	updated, err := repo.Get("welcome", "en")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Welcome aboard", updated.Subject)
+	}
+
+	_, err = repo.Get("reset", "en")
+	assert.Equal(t, communication.TemplateNotFoundErr, err)
+
+	// Failed reloads keep the current templates
+	files["welcome/en/unknown.txt"] = &fstest.MapFile{Data: []byte("")}
+
+	assert.Error(t, repo.Reload())
+
+	_, err = repo.Get("welcome", "en")
+	assert.NoError(t, err)
+}
+
+func TestTemplateRepositoryMatching(t *testing.T) {
+	files := fstest.MapFS{
+		"welcome/en/subject.txt": {Data: []byte("Welcome")},
+		"welcome/sv/subject.txt": {Data: []byte("Välkommen")},
+		"reset/en/subject.txt":   {Data: []byte("Reset your password")},
+		"reset/en/meta.json":     {Data: []byte(`{"enabled": false}`)},
+		"base/en/body.html":      {Data: []byte(`<main>{{ template "content" . }}</main>`)},
+		"base/en/meta.json":      {Data: []byte(`{"kind": "layout"}`)},
+	}
+
+	repo, err := NewTemplateRepository(files)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ids := func(templates []communication.Template) []string {
+		keys := make([]string, 0, len(templates))
+		for _, template := range templates {
+			keys = append(keys, template.Locale+":"+template.TemplateId)
+		}
+
+		return keys
+	}
+
+	// Without sorting the templates are ordered by id and locale, so pages neither repeat nor skip templates
+	var paged []string
+	for offset := 0; offset < 4; offset += 2 {
+		templates, count, err := repo.Matching(communication.TemplateCriteria{Offset: offset, Limit: 2})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, 4, count)
+		paged = append(paged, ids(templates)...)
+	}
+
+	assert.Equal(t, []string{"en:base", "en:reset", "en:welcome", "sv:welcome"}, paged)
+
+	templates, count, err := repo.Matching(communication.TemplateCriteria{Offset: 4, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+	assert.Empty(t, templates)
+
+	// Sorted columns are compared in a fixed order, locale before enabled
+	templates, _, _ = repo.Matching(communication.TemplateCriteria{Sorting: map[string]string{"enabled": "asc", "locale": "desc"}})
+	assert.Equal(t, []string{"sv:welcome", "en:reset", "en:base", "en:welcome"}, ids(templates))
+
+	templates, _, _ = repo.Matching(communication.TemplateCriteria{Kind: string(communication.TemplateKindContent)})
+	assert.Equal(t, []string{"en:reset", "en:welcome", "sv:welcome"}, ids(templates))
+
+	templates, _, _ = repo.Matching(communication.TemplateCriteria{Kind: string(communication.TemplateKindLayout)})
+	assert.Equal(t, []string{"en:base"}, ids(templates))
+
+	templates, _, _ = repo.Matching(communication.TemplateCriteria{TemplateId: "wel", Locale: "SV"})
+	assert.Equal(t, []string{"sv:welcome"}, ids(templates))
+
+	templates, _, _ = repo.Matching(communication.TemplateCriteria{Subject: "reset"})
+	assert.Equal(t, []string{"en:reset"}, ids(templates))
+}
+
+func TestTemplateRepositoryReadOnly(t *testing.T) {
+	repo, err := NewTemplateRepository(fstest.MapFS{
+		"welcome/en/subject.txt": {Data: []byte("Welcome")},
+	})
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	template, err := repo.Get("welcome", "en")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, ReadOnlyErr, repo.Create(&template))
+	assert.Equal(t, ReadOnlyErr, repo.Update(&template))
+	assert.Equal(t, ReadOnlyErr, repo.Delete(&template))
+	assert.NoError(t, repo.Publish("welcome", "en"))
+}
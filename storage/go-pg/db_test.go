@@ -0,0 +1,88 @@
+package gopg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// queryRecorder is a minimal postgres server recording the queries it receives, every query fails
+type queryRecorder struct {
+	sync.Mutex
+	queries []string
+
+	// code is the sql state of the errors returned, XX000 when empty
+	code string
+}
+
+func (rec *queryRecorder) dial(network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+
+	go rec.serve(server)
+
+	return client, nil
+}
+
+func (rec *queryRecorder) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	// The startup message has no type byte
+	if _, err := readMessage(r); err != nil {
+		return
+	}
+
+	conn.Write(message('R', []byte{0, 0, 0, 0}))
+	conn.Write(message('Z', []byte{'I'}))
+
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+
+		body, err := readMessage(r)
+		if err != nil || typ == 'X' {
+			return
+		}
+
+		if typ != 'Q' {
+			continue
+		}
+
+		rec.Lock()
+		rec.queries = append(rec.queries, strings.TrimRight(string(body), "\x00"))
+		code := rec.code
+		rec.Unlock()
+
+		if code == "" {
+			code = "XX000"
+		}
+
+		conn.Write(message('E', []byte("SERROR\x00C"+code+"\x00Mrecorded\x00\x00")))
+		conn.Write(message('Z', []byte{'I'}))
+	}
+}
+
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	var length int32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, length-4)
+	_, err := io.ReadFull(r, body)
+
+	return body, err
+}
+
+func message(typ byte, body []byte) []byte {
+	out := []byte{typ, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(out[1:], uint32(len(body)+4))
+
+	return append(out, body...)
+}
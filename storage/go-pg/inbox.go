@@ -0,0 +1,102 @@
+package gopg
+
+import (
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/types"
+	"github.com/google/uuid"
+	"github.com/interactive-solutions/go-communication"
+)
+
+func NewInboxRepository(db *pg.DB) communication.InboxRepository {
+	return &inboxRepository{
+		db: db,
+	}
+}
+
+type inboxWrapper struct {
+	TableName struct{} `sql:"communication_inbox,alias:ci" json:"-"`
+
+	*communication.InboxMessage
+}
+
+type inboxRepository struct {
+	db *pg.DB
+}
+
+func (repo *inboxRepository) Get(id uuid.UUID) (communication.InboxMessage, error) {
+	wrapped := &inboxWrapper{
+		InboxMessage: &communication.InboxMessage{},
+	}
+
+	if err := repo.db.Model(wrapped).Where("uuid = ?", id).Select(); err != nil {
+		if err == pg.ErrNoRows {
+			return *wrapped.InboxMessage, communication.InboxMessageNotFoundErr
+		}
+
+		return *wrapped.InboxMessage, err
+	}
+
+	return *wrapped.InboxMessage, nil
+}
+
+func (repo *inboxRepository) Create(message *communication.InboxMessage) error {
+	err := repo.db.Insert(&inboxWrapper{InboxMessage: message})
+
+	// 23505 is the unique violation raised when the uuid is already stored
+	if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == "23505" {
+		return communication.InboxMessageExistsErr
+	}
+
+	return err
+}
+
+func (repo *inboxRepository) Update(message *communication.InboxMessage) error {
+	return repo.db.Update(&inboxWrapper{InboxMessage: message})
+}
+
+func (repo *inboxRepository) Delete(message *communication.InboxMessage) error {
+	return repo.db.Delete(&inboxWrapper{InboxMessage: message})
+}
+
+func (repo *inboxRepository) CountUnread(recipient string) (int, error) {
+	return repo.db.Model(&inboxWrapper{}).
+		Where("recipient = ?", recipient).
+		Where("read_at is null").
+		Count()
+}
+
+func (repo *inboxRepository) Matching(criteria communication.InboxCriteria) ([]communication.InboxMessage, int, error) {
+	var wrapped []inboxWrapper
+	messages := make([]communication.InboxMessage, 0)
+
+	builder := repo.db.Model(&wrapped).
+		Offset(criteria.Offset).
+		Limit(criteria.Limit)
+
+	if criteria.Recipient != "" {
+		builder.Where("recipient = ?", criteria.Recipient)
+	}
+
+	if criteria.TemplateId != "" {
+		builder.Where("template_id like ?", criteria.TemplateId+"%")
+	}
+
+	if criteria.Unread {
+		builder.Where("read_at is null")
+	}
+
+	for col, dir := range criteria.Sorting {
+		builder.OrderExpr("? ?", types.F(col), types.Q(dir))
+	}
+
+	count, err := builder.SelectAndCount()
+	if err != nil && err != pg.ErrNoRows {
+		return messages, 0, err
+	}
+
+	for _, m := range wrapped {
+		messages = append(messages, *m.InboxMessage)
+	}
+
+	return messages, count, nil
+}
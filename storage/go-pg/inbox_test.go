@@ -0,0 +1,30 @@
+package gopg
+
+import (
+	"testing"
+
+	"github.com/go-pg/pg"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/interactive-solutions/go-communication"
+)
+
+func TestInboxCreateDuplicate(t *testing.T) {
+	rec := &queryRecorder{code: "23505"}
+
+	db := pg.Connect(&pg.Options{Dialer: rec.dial})
+	defer db.Close()
+
+	repo := NewInboxRepository(db)
+	assert.Equal(t, communication.InboxMessageExistsErr, repo.Create(&communication.InboxMessage{Uuid: uuid.New()}))
+
+	rec.Lock()
+	rec.code = ""
+	rec.Unlock()
+
+	err := repo.Create(&communication.InboxMessage{Uuid: uuid.New()})
+	if assert.Error(t, err) {
+		assert.NotEqual(t, communication.InboxMessageExistsErr, err, "Other errors should be returned as is")
+	}
+}
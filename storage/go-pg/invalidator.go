@@ -0,0 +1,57 @@
+package gopg
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-pg/pg"
+	"github.com/interactive-solutions/go-communication"
+)
+
+const templateInvalidationChannel = "communication_templates_invalidated"
+
+// NewTemplateInvalidator propagates template invalidations between instances using LISTEN/NOTIFY
+func NewTemplateInvalidator(db *pg.DB) communication.TemplateInvalidator {
+	return &templateInvalidator{
+		db: db,
+	}
+}
+
+type templateInvalidator struct {
+	db *pg.DB
+}
+
+func (inv *templateInvalidator) Publish(templateId, locale string) error {
+	_, err := inv.db.Exec("SELECT pg_notify(?, ?)", templateInvalidationChannel, locale+":"+templateId)
+	return err
+}
+
+func (inv *templateInvalidator) Subscribe(ctx context.Context, handler func(templateId, locale string)) error {
+	listener := inv.db.Listen(templateInvalidationChannel)
+	notifications := listener.Channel()
+
+	go func() {
+		defer listener.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case notification, ok := <-notifications:
+				if !ok {
+					return
+				}
+
+				split := strings.SplitN(notification.Payload, ":", 2)
+				if len(split) != 2 {
+					continue
+				}
+
+				handler(split[1], split[0])
+			}
+		}
+	}()
+
+	return nil
+}
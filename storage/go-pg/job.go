@@ -2,6 +2,9 @@ package gopg
 
 import (
 	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/go-pg/pg/types"
+	"github.com/google/uuid"
 	"github.com/interactive-solutions/go-communication"
 )
 
@@ -29,11 +32,27 @@ func (repo *jobRepository) Update(job *communication.Job) error {
 	return repo.db.Update(&jobWrapper{Job: job})
 }
 
+func (repo *jobRepository) Get(id uuid.UUID) (communication.Job, error) {
+	wrapped := &jobWrapper{
+		Job: &communication.Job{},
+	}
+
+	if err := repo.db.Model(wrapped).Where("uuid = ?", id).Select(); err != nil {
+		if err == pg.ErrNoRows {
+			return *wrapped.Job, communication.JobNotFoundErr
+		}
+
+		return *wrapped.Job, err
+	}
+
+	return *wrapped.Job, nil
+}
+
 func (repo *jobRepository) GetPending() ([]communication.Job, error) {
 	var jobs []communication.Job
 	var wrappedJobs []jobWrapper
 
-	if err := repo.db.Model(&wrappedJobs).Where("sent_at is null").Select(); err != nil {
+	if err := repo.db.Model(&wrappedJobs).Where("sent_at is null AND failed_at is null AND held_at is null").Select(); err != nil {
 		if err == pg.ErrNoRows {
 			return jobs, nil
 		}
@@ -72,10 +91,34 @@ func (repo *jobRepository) Matching(criteria communication.JobCriteria) ([]commu
 		builder.Where("LOWER(target) = LOWER(?)", criteria.Target+"%")
 	}
 
+	if criteria.Recipient != "" {
+		builder.WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			q.WhereOr("LOWER(target) = LOWER(?)", criteria.Recipient)
+
+			for _, column := range []string{"to_addresses", "cc_addresses", "bcc_addresses"} {
+				q.WhereOr("EXISTS (SELECT 1 FROM unnest(?) AS address WHERE LOWER(address) = LOWER(?))", types.F(column), criteria.Recipient)
+			}
+
+			return q, nil
+		})
+	}
+
 	if criteria.ExternalId != "" {
 		builder.Where("external_id = ?", criteria.ExternalId)
 	}
 
+	if criteria.ChainUuid != "" {
+		builder.Where("chain_uuid = ?", criteria.ChainUuid)
+	}
+
+	if criteria.Held {
+		builder.Where("held_at is not null")
+	}
+
+	if criteria.Escalating {
+		builder.Where("escalate_at is not null AND delivered_at is null")
+	}
+
 	if !criteria.SentAfter.IsZero() {
 		builder.Where("sent_at >= ?", criteria.SentAfter)
 	}
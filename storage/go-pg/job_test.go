@@ -0,0 +1,35 @@
+package gopg
+
+import (
+	"testing"
+
+	"github.com/go-pg/pg"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/interactive-solutions/go-communication"
+)
+
+func TestJobMatchingRecipient(t *testing.T) {
+	rec := &queryRecorder{}
+
+	db := pg.Connect(&pg.Options{Dialer: rec.dial})
+	defer db.Close()
+
+	_, _, err := NewJobRepository(db).Matching(communication.JobCriteria{Limit: 10, Recipient: "Anna@Example.com"})
+	assert.Error(t, err)
+
+	rec.Lock()
+	defer rec.Unlock()
+
+	if !assert.NotEmpty(t, rec.queries) {
+		return
+	}
+
+	for _, query := range rec.queries {
+		assert.Contains(t, query, `(LOWER(target) = LOWER('Anna@Example.com'))`)
+
+		for _, column := range []string{"to_addresses", "cc_addresses", "bcc_addresses"} {
+			assert.Contains(t, query, `EXISTS (SELECT 1 FROM unnest("`+column+`") AS address WHERE LOWER(address) = LOWER('Anna@Example.com'))`)
+		}
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"github.com/interactive-solutions/go-communication"
 )
 
+// columns the templates can be sorted on, in the order they are compared
+var templateSortColumns = []string{"template_id", "locale", "enabled", "updated_at", "created_at"}
+
 func NewTemplateRepository(db *pg.DB) communication.TemplateRepository {
 	return &templateRepository{
 		db: db,
@@ -71,6 +74,17 @@ func (repo *templateRepository) Matching(criteria communication.TemplateCriteria
 		builder.Where("LOWER(locale) = LOWER(?)", criteria.Locale)
 	}
 
+	switch communication.TemplateKind(criteria.Kind) {
+	case "":
+
+	case communication.TemplateKindContent:
+		// Templates created before kinds were introduced are content templates
+		builder.Where("(kind IS NULL OR kind = ?)", criteria.Kind)
+
+	default:
+		builder.Where("kind = ?", criteria.Kind)
+	}
+
 	if criteria.Subject != "" {
 		builder.Where("LOWER(subject) = LOWER(?)", criteria.Subject+"%")
 	}
@@ -83,8 +97,11 @@ func (repo *templateRepository) Matching(criteria communication.TemplateCriteria
 		builder.Where("updated_at <= ?", criteria.UpdatedBefore)
 	}
 
-	for col, dir := range criteria.Sorting {
-		builder.OrderExpr("? ?", types.F(col), types.Q(dir))
+	// Columns are sorted in a fixed order, ranging over the map would change the order between pages
+	for _, col := range templateSortColumns {
+		if dir, ok := criteria.Sorting[col]; ok {
+			builder.OrderExpr("? ?", types.F(col), types.Q(dir))
+		}
 	}
 
 	count, err := builder.SelectAndCount()
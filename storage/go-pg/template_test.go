@@ -0,0 +1,45 @@
+package gopg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-pg/pg"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/interactive-solutions/go-communication"
+)
+
+func TestTemplateMatchingSorting(t *testing.T) {
+	rec := &queryRecorder{}
+
+	db := pg.Connect(&pg.Options{Dialer: rec.dial})
+	defer db.Close()
+
+	sorting := map[string]string{"locale": "asc", "template_id": "asc", "updated_at": "desc"}
+
+	for i := 0; i < 5; i++ {
+		_, _, err := NewTemplateRepository(db).Matching(communication.TemplateCriteria{Limit: 10, Sorting: sorting})
+		assert.Error(t, err)
+	}
+
+	rec.Lock()
+	defer rec.Unlock()
+
+	if !assert.NotEmpty(t, rec.queries) {
+		return
+	}
+
+	selects := 0
+
+	for _, query := range rec.queries {
+		if strings.Contains(query, "count(*)") {
+			continue
+		}
+
+		selects++
+		assert.Contains(t, query, `ORDER BY "template_id" asc, "locale" asc, "updated_at" desc LIMIT 10`)
+	}
+
+	assert.Equal(t, 5, selects)
+}
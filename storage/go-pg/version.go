@@ -0,0 +1,94 @@
+package gopg
+
+import (
+	"reflect"
+
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/interactive-solutions/go-communication"
+	"github.com/pkg/errors"
+)
+
+func NewTemplateVersionRepository(db *pg.DB) communication.TemplateVersionRepository {
+	return &templateVersionRepository{
+		db: db,
+	}
+}
+
+type templateVersionRepository struct {
+	db *pg.DB
+}
+
+type templateVersionWrapper struct {
+	TableName struct{} `sql:"communication_template_versions,alias:ctv" json:"-"`
+
+	*communication.TemplateVersion
+}
+
+func (repo *templateVersionRepository) Get(id, locale string, version int) (communication.TemplateVersion, error) {
+	wrapped := &templateVersionWrapper{
+		TemplateVersion: &communication.TemplateVersion{},
+	}
+
+	if err := repo.db.Model(wrapped).Where("template_id = ? AND locale = ? AND version = ?", id, locale, version).Select(); err != nil {
+		if err == pg.ErrNoRows {
+			return *wrapped.TemplateVersion, communication.TemplateVersionNotFoundErr
+		}
+
+		return *wrapped.TemplateVersion, err
+	}
+
+	return *wrapped.TemplateVersion, nil
+}
+
+func (repo *templateVersionRepository) Matching(criteria communication.TemplateVersionCriteria) ([]communication.TemplateVersion, int, error) {
+	var wrapped []templateVersionWrapper
+	versions := make([]communication.TemplateVersion, 0)
+
+	builder := repo.db.Model(&wrapped).
+		Offset(criteria.Offset).
+		Limit(criteria.Limit).
+		Order("version DESC")
+
+	if criteria.TemplateId != "" {
+		builder.Where("template_id = ?", criteria.TemplateId)
+	}
+
+	if criteria.Locale != "" {
+		builder.Where("locale = ?", criteria.Locale)
+	}
+
+	count, err := builder.SelectAndCount()
+	if err != nil && err != pg.ErrNoRows {
+		return versions, 0, err
+	}
+
+	for _, v := range wrapped {
+		versions = append(versions, *v.TemplateVersion)
+	}
+
+	return versions, count, nil
+}
+
+// Create replaces versions the template never reached, left behind when storing the template failed after its version
+func (repo *templateVersionRepository) Create(version *communication.TemplateVersion) error {
+	query := repo.db.Model(&templateVersionWrapper{TemplateVersion: version}).
+		OnConflict("(template_id, locale, version) DO UPDATE")
+
+	for _, field := range orm.GetTable(reflect.TypeOf(templateVersionWrapper{})).DataFields {
+		query.Set("? = EXCLUDED.?", field.Column, field.Column)
+	}
+
+	query.Where("NOT EXISTS (SELECT 1 FROM communication_templates AS ct WHERE ct.template_id = ctv.template_id AND ct.locale = ctv.locale AND ct.version >= ctv.version)")
+
+	result, err := query.Insert()
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.Errorf("Version %d of template %s already exists", version.Version, version.TemplateId)
+	}
+
+	return nil
+}
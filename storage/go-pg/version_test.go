@@ -0,0 +1,29 @@
+package gopg
+
+import (
+	"testing"
+
+	"github.com/go-pg/pg"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/interactive-solutions/go-communication"
+)
+
+func TestTemplateVersionCreateReplacesOrphans(t *testing.T) {
+	rec := &queryRecorder{}
+
+	db := pg.Connect(&pg.Options{Dialer: rec.dial})
+	defer db.Close()
+
+	err := NewTemplateVersionRepository(db).Create(&communication.TemplateVersion{TemplateId: "welcome", Locale: "en", Version: 2, Subject: "Hi"})
+	assert.Error(t, err)
+
+	rec.Lock()
+	defer rec.Unlock()
+
+	if assert.Len(t, rec.queries, 1) {
+		assert.Contains(t, rec.queries[0], `ON CONFLICT (template_id, locale, version) DO UPDATE SET "description" = EXCLUDED."description"`)
+		assert.Contains(t, rec.queries[0], `"subject" = EXCLUDED."subject"`)
+		assert.Contains(t, rec.queries[0], "WHERE (NOT EXISTS (SELECT 1 FROM communication_templates AS ct WHERE ct.template_id = ctv.template_id AND ct.locale = ctv.locale AND ct.version >= ctv.version))")
+	}
+}
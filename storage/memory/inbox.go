@@ -0,0 +1,176 @@
+package memory
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/interactive-solutions/go-communication"
+)
+
+// NewInboxRepository creates an inbox repository that keeps all messages in memory
+func NewInboxRepository() communication.InboxRepository {
+	return &inboxRepository{
+		messages: map[uuid.UUID]communication.InboxMessage{},
+	}
+}
+
+type inboxRepository struct {
+	sync.RWMutex
+
+	messages map[uuid.UUID]communication.InboxMessage
+}
+
+func (repo *inboxRepository) Get(id uuid.UUID) (communication.InboxMessage, error) {
+	repo.RLock()
+	defer repo.RUnlock()
+
+	message, ok := repo.messages[id]
+	if !ok {
+		return message, communication.InboxMessageNotFoundErr
+	}
+
+	return message, nil
+}
+
+func (repo *inboxRepository) Create(message *communication.InboxMessage) error {
+	repo.Lock()
+	defer repo.Unlock()
+
+	if _, ok := repo.messages[message.Uuid]; ok {
+		return communication.InboxMessageExistsErr
+	}
+
+	repo.messages[message.Uuid] = *message
+
+	return nil
+}
+
+func (repo *inboxRepository) Update(message *communication.InboxMessage) error {
+	repo.Lock()
+	defer repo.Unlock()
+
+	if _, ok := repo.messages[message.Uuid]; !ok {
+		return communication.InboxMessageNotFoundErr
+	}
+
+	repo.messages[message.Uuid] = *message
+
+	return nil
+}
+
+func (repo *inboxRepository) Delete(message *communication.InboxMessage) error {
+	repo.Lock()
+	defer repo.Unlock()
+
+	delete(repo.messages, message.Uuid)
+
+	return nil
+}
+
+func (repo *inboxRepository) CountUnread(recipient string) (int, error) {
+	repo.RLock()
+	defer repo.RUnlock()
+
+	count := 0
+
+	for _, message := range repo.messages {
+		if message.Recipient == recipient && message.ReadAt == nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (repo *inboxRepository) Matching(criteria communication.InboxCriteria) ([]communication.InboxMessage, int, error) {
+	repo.RLock()
+	defer repo.RUnlock()
+
+	messages := make([]communication.InboxMessage, 0)
+
+	for _, message := range repo.messages {
+		if criteria.Recipient != "" && message.Recipient != criteria.Recipient {
+			continue
+		}
+
+		if criteria.TemplateId != "" && !strings.HasPrefix(message.TemplateId, criteria.TemplateId) {
+			continue
+		}
+
+		if criteria.Unread && message.ReadAt != nil {
+			continue
+		}
+
+		messages = append(messages, message)
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return lessInboxMessage(messages[i], messages[j], criteria.Sorting)
+	})
+
+	count := len(messages)
+
+	if criteria.Offset >= len(messages) {
+		return messages[:0], count, nil
+	}
+
+	messages = messages[criteria.Offset:]
+
+	if criteria.Limit > 0 && criteria.Limit < len(messages) {
+		messages = messages[:criteria.Limit]
+	}
+
+	return messages, count, nil
+}
+
+func lessInboxMessage(a, b communication.InboxMessage, sorting map[string]string) bool {
+	for _, column := range []string{"created_at", "read_at"} {
+		dir, ok := sorting[column]
+		if !ok {
+			continue
+		}
+
+		var cmp int
+
+		switch column {
+		case "created_at":
+			cmp = compareTime(a.CreatedAt.UnixNano(), b.CreatedAt.UnixNano())
+
+		case "read_at":
+			var ar, br int64
+			if a.ReadAt != nil {
+				ar = a.ReadAt.UnixNano()
+			}
+			if b.ReadAt != nil {
+				br = b.ReadAt.UnixNano()
+			}
+
+			cmp = compareTime(ar, br)
+		}
+
+		if cmp == 0 {
+			continue
+		}
+
+		if dir == "desc" {
+			return cmp > 0
+		}
+
+		return cmp < 0
+	}
+
+	return a.CreatedAt.After(b.CreatedAt)
+}
+
+func compareTime(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/interactive-solutions/go-communication"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInboxRepository(t *testing.T) {
+	repo := NewInboxRepository()
+
+	now := time.Now()
+	first := &communication.InboxMessage{Uuid: uuid.New(), Recipient: "anna", TemplateId: "order-sent", CreatedAt: now.Add(-time.Hour)}
+	second := &communication.InboxMessage{Uuid: uuid.New(), Recipient: "anna", TemplateId: "welcome", CreatedAt: now}
+	other := &communication.InboxMessage{Uuid: uuid.New(), Recipient: "erik", TemplateId: "welcome", CreatedAt: now}
+
+	for _, message := range []*communication.InboxMessage{first, second, other} {
+		assert.NoError(t, repo.Create(message))
+	}
+
+	assert.Equal(t, communication.InboxMessageExistsErr, repo.Create(first), "Duplicate uuids should be rejected")
+
+	count, err := repo.CountUnread("anna")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	first.ReadAt = &now
+	assert.NoError(t, repo.Update(first))
+
+	count, _ = repo.CountUnread("anna")
+	assert.Equal(t, 1, count)
+
+	messages, total, err := repo.Matching(communication.InboxCriteria{Recipient: "anna", Sorting: map[string]string{"created_at": "asc"}})
+	if assert.NoError(t, err) && assert.Len(t, messages, 2) {
+		assert.Equal(t, 2, total)
+		assert.Equal(t, first.Uuid, messages[0].Uuid)
+	}
+
+	messages, _, _ = repo.Matching(communication.InboxCriteria{Recipient: "anna", Unread: true})
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, second.Uuid, messages[0].Uuid)
+	}
+
+	messages, _, _ = repo.Matching(communication.InboxCriteria{TemplateId: "order"})
+	assert.Len(t, messages, 1)
+
+	messages, total, _ = repo.Matching(communication.InboxCriteria{Offset: 1, Limit: 1})
+	assert.Len(t, messages, 1)
+	assert.Equal(t, 3, total)
+
+	assert.NoError(t, repo.Delete(second))
+
+	_, err = repo.Get(second.Uuid)
+	assert.Equal(t, communication.InboxMessageNotFoundErr, err)
+
+	assert.Equal(t, communication.InboxMessageNotFoundErr, repo.Update(second))
+}
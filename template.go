@@ -1,25 +1,214 @@
 package communication
 
-import "time"
+import (
+	"net/mail"
+	"time"
+
+	"github.com/pkg/errors"
+)
 
 type Template struct {
 	TemplateId string `sql:",pk" json:"id"`
 	Locale     string `sql:",pk" json:"locale"`
 
+	// Version is incremented each time the template is changed through the http handler
+	Version int `sql:",notnull" json:"version"`
+
 	Enabled     bool   `sql:",notnull" json:"enabled"`
 	Description string `sql:",notnull" json:"description"`
 
+	// Kind defaults to content, Layout is the id of the layout content templates are rendered in
+	Kind   TemplateKind `json:"kind"`
+	Layout string       `json:"layout"`
+
 	Parameters       map[string]interface{} `json:"parameters"`
 	UpdateParameters bool                   `sql:",notnull" json:"updateParameters"`
+	Schema           ParameterSchema        `json:"schema"`
 
 	Subject  string `json:"subject"`
 	TextBody string `json:"textBody"`
 	HtmlBody string `json:"htmlBody"`
 
+	// BodyFormat is the format HtmlBody is written in, defaults to html
+	BodyFormat BodyFormat `json:"bodyFormat"`
+
+	// Sender identity overrides, transports use their own configuration when empty
+	FromName    string `json:"fromName"`
+	FromAddress string `json:"fromAddress"`
+	ReplyTo     string `json:"replyTo"`
+	SmsSender   string `json:"smsSender"`
+
+	// SmsMaxSegments limits the length of sms bodies when set, longer bodies are handled according to SmsLimitAction
+	SmsMaxSegments int            `json:"smsMaxSegments"`
+	SmsLimitAction SmsLimitAction `json:"smsLimitAction"`
+
+	// SeedChecksum is the checksum of the content when it was last seeded from files
+	SeedChecksum string `json:"seedChecksum"`
+
+	// Draft holds unpublished changes when using the draft workflow, it is never sent to customers
+	Draft *TemplateVersion `json:"draft"`
+
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// EmailFrom returns the from address of the template, using fallback for the parts not overridden
+func (t Template) EmailFrom(fallback string) string {
+	if t.FromName == "" && t.FromAddress == "" {
+		return fallback
+	}
+
+	address := mail.Address{
+		Name:    t.FromName,
+		Address: t.FromAddress,
+	}
+
+	if parsed, err := mail.ParseAddress(fallback); err == nil {
+		if address.Address == "" {
+			address.Address = parsed.Address
+		}
+
+		if t.FromName == "" {
+			address.Name = parsed.Name
+		}
+	} else if address.Address == "" {
+		return fallback
+	}
+
+	return address.String()
+}
+
+// EmailReplyTo returns the reply to address of the template or fallback if not overridden
+func (t Template) EmailReplyTo(fallback string) string {
+	if t.ReplyTo != "" {
+		return t.ReplyTo
+	}
+
+	return fallback
+}
+
+// SmsFrom returns the sms sender id of the template or fallback if not overridden
+func (t Template) SmsFrom(fallback string) string {
+	if t.SmsSender != "" {
+		return t.SmsSender
+	}
+
+	return fallback
+}
+
+// validateSmsSender checks the sender against the limits of sms networks, either a phone number
+// of at most 15 digits or an alphanumeric sender id of at most 11 characters
+func validateSmsSender(sender string) error {
+	if sender == "" {
+		return nil
+	}
+
+	digits := 0
+	numeric := true
+
+	for i, r := range sender {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+
+		case r == '+' && i == 0:
+
+		case (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == ' ' || r == '-' || r == '.':
+			numeric = false
+
+		default:
+			return errors.Errorf("Invalid sms sender %q, only a-z, 0-9, space, dash and dot are supported", sender)
+		}
+	}
+
+	if numeric {
+		if digits == 0 || digits > 15 {
+			return errors.Errorf("Numeric sms sender %q must have between 1 and 15 digits", sender)
+		}
+
+		return nil
+	}
+
+	if sender[0] == '+' {
+		return errors.Errorf("Invalid sms sender %q", sender)
+	}
+
+	if len(sender) > 11 {
+		return errors.Errorf("Alphanumeric sms sender %q is longer than 11 characters", sender)
+	}
+
+	return nil
+}
+
+// validateTemplate checks the content of a template before it is stored or published and returns
+// the text body rendered with example parameters
+func (a *application) validateTemplate(template Template) (string, error) {
+	if err := template.Schema.Validate(); err != nil {
+		return "", errors.Wrap(err, "Invalid parameter schema")
+	}
+
+	if err := validateSmsLimit(template); err != nil {
+		return "", err
+	}
+
+	if err := validateSmsSender(template.SmsSender); err != nil {
+		return "", err
+	}
+
+	for _, address := range []string{template.FromAddress, template.ReplyTo} {
+		if address == "" {
+			continue
+		}
+
+		if _, err := mail.ParseAddress(address); err != nil {
+			return "", errors.Errorf("Invalid email address %s", address)
+		}
+	}
+
+	_, text, _, err := a.renderAll(template, &Job{Params: template.Schema.Example(template.Parameters)})
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to render template")
+	}
+
+	return text, nil
+}
+
+// validatorWith returns a copy of the application resolving the given templates before the repository,
+// so templates stored together can be validated against the layouts and partials among them
+func (a *application) validatorWith(templates []Template) *application {
+	repo := overlayRepository{
+		TemplateRepository: a.templateRepo,
+		templates:          make(map[templateKey]Template, len(templates)),
+	}
+
+	for _, template := range templates {
+		repo.templates[templateKey{template.TemplateId, template.Locale}] = template
+	}
+
+	validator := *a
+	validator.templateRepo = repo
+	validator.templateCache = newTemplateCache()
+
+	return &validator
+}
+
+// overlayRepository resolves templates that are not stored yet before falling back to the repository
+type overlayRepository struct {
+	TemplateRepository
+
+	templates map[templateKey]Template
+}
+
+func (repo overlayRepository) Get(templateId, locale string) (Template, error) {
+	if template, ok := repo.templates[templateKey{templateId, locale}]; ok {
+		return template, nil
+	}
+
+	return repo.TemplateRepository.Get(templateId, locale)
+}
+
+// TemplateService renders managed templates without sending them
 type TemplateService interface {
-	Render(id, locale string, parameters map[string]interface{}) error
+	// Render resolves the template through the locale chain used for jobs and renders all of its fields
+	Render(id, locale string, parameters map[string]interface{}) (RenderedTemplate, error)
 }
@@ -0,0 +1,158 @@
+package communication
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateVersion is an immutable snapshot of the content of a template
+type TemplateVersion struct {
+	TemplateId string `sql:",pk" json:"id"`
+	Locale     string `sql:",pk" json:"locale"`
+	Version    int    `sql:",pk" json:"version"`
+
+	Description string          `json:"description"`
+	Layout      string          `json:"layout"`
+	Schema      ParameterSchema `json:"schema"`
+
+	Subject    string     `json:"subject"`
+	TextBody   string     `json:"textBody"`
+	HtmlBody   string     `json:"htmlBody"`
+	BodyFormat BodyFormat `json:"bodyFormat"`
+
+	FromName    string `json:"fromName"`
+	FromAddress string `json:"fromAddress"`
+	ReplyTo     string `json:"replyTo"`
+	SmsSender   string `json:"smsSender"`
+
+	SmsMaxSegments int            `json:"smsMaxSegments"`
+	SmsLimitAction SmsLimitAction `json:"smsLimitAction"`
+
+	// Enabled is only used by drafts, publishing the draft enables or disables the template
+	Enabled bool `sql:"-" json:"enabled,omitempty"`
+
+	// Author is resolved from the request that changed the template, ApprovedBy is set when
+	// publishing a draft requires approval and RestoredFrom is set by rollbacks
+	Author       string `json:"author"`
+	ApprovedBy   string `json:"approvedBy"`
+	RestoredFrom *int   `json:"restoredFrom"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AuthorResolver returns the name of the user making a change to a template
+type AuthorResolver func(r *http.Request) string
+
+// SetTemplateVersionRepo enables version history, a version is stored each time a template changes
+func SetTemplateVersionRepo(repo TemplateVersionRepository) AppOption {
+	return func(a *application) {
+		a.templateVersionRepo = repo
+	}
+}
+
+// SetAuthorResolver configures how the author of template changes is identified
+func SetAuthorResolver(resolver AuthorResolver) AppOption {
+	return func(a *application) {
+		a.authorResolver = resolver
+	}
+}
+
+func newTemplateVersion(template Template) TemplateVersion {
+	return TemplateVersion{
+		TemplateId:  template.TemplateId,
+		Locale:      template.Locale,
+		Version:     template.Version,
+		Description: template.Description,
+		Layout:      template.Layout,
+		Schema:      template.Schema,
+		Subject:     template.Subject,
+		TextBody:    template.TextBody,
+		HtmlBody:    template.HtmlBody,
+		BodyFormat:  template.BodyFormat,
+		FromName:    template.FromName,
+		FromAddress: template.FromAddress,
+		ReplyTo:     template.ReplyTo,
+		SmsSender:   template.SmsSender,
+
+		SmsMaxSegments: template.SmsMaxSegments,
+		SmsLimitAction: template.SmsLimitAction,
+		CreatedAt:      time.Now(),
+	}
+}
+
+// restore copies the content of the version onto the template
+func (v TemplateVersion) restore(template *Template) {
+	template.Description = v.Description
+	template.Layout = v.Layout
+	template.Schema = v.Schema
+	template.Subject = v.Subject
+	template.TextBody = v.TextBody
+	template.HtmlBody = v.HtmlBody
+	template.BodyFormat = v.BodyFormat
+	template.FromName = v.FromName
+	template.FromAddress = v.FromAddress
+	template.ReplyTo = v.ReplyTo
+	template.SmsSender = v.SmsSender
+	template.SmsMaxSegments = v.SmsMaxSegments
+	template.SmsLimitAction = v.SmsLimitAction
+}
+
+// DiffTemplateVersions returns the content fields that differ between two versions
+func DiffTemplateVersions(from, to TemplateVersion) []FieldChange {
+	var previous, next Template
+
+	from.restore(&previous)
+	to.restore(&next)
+
+	changes := []FieldChange{}
+
+	for _, field := range bundleFields {
+		before, after := field.get(&previous), field.get(&next)
+		if !sameBundleValue(before, after) {
+			changes = append(changes, FieldChange{Field: field.name, From: before, To: after})
+		}
+	}
+
+	return changes
+}
+
+func (a *application) author(r *http.Request) string {
+	if a.authorResolver == nil {
+		return ""
+	}
+
+	return a.authorResolver(r)
+}
+
+// recordVersion stores the version of a changed template, templates changed for the first
+// time since versioning was enabled also get their previous content stored
+func (a *application) recordVersion(previous Template, version TemplateVersion) error {
+	if a.templateVersionRepo == nil {
+		return nil
+	}
+
+	// Templates created as drafts have no content before their first version
+	if previous.Version == 0 && previous.TemplateId != "" && !previous.empty() {
+		// The baseline is already stored when a previous attempt failed to store the template
+		if _, err := a.templateVersionRepo.Get(previous.TemplateId, previous.Locale, 0); err == nil {
+			return a.createVersion(version)
+		} else if err != TemplateVersionNotFoundErr {
+			return errors.Wrapf(err, "Failed to retrieve version 0 of template %s", version.TemplateId)
+		}
+
+		baseline := newTemplateVersion(previous)
+		baseline.CreatedAt = previous.UpdatedAt
+
+		if err := a.templateVersionRepo.Create(&baseline); err != nil {
+			return errors.Wrapf(err, "Failed to store version 0 of template %s", version.TemplateId)
+		}
+	}
+
+	return a.createVersion(version)
+}
+
+func (a *application) createVersion(version TemplateVersion) error {
+	return errors.Wrapf(a.templateVersionRepo.Create(&version), "Failed to store version %d of template %s", version.Version, version.TemplateId)
+}